@@ -0,0 +1,37 @@
+package accounts
+
+import (
+	"github.com/f-taxes/kraken_import/conf"
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/gookit/config/v2"
+)
+
+func init() {
+	conf.RegisterMigration(conf.Migration{
+		Version:     1,
+		Description: "Move accounts from the app config into the accounts file and encrypt their credentials",
+		Up:          moveAccountsOutOfAppConfig,
+	})
+}
+
+func moveAccountsOutOfAppConfig(cfg *config.Config) error {
+	legacy := []g.Account{}
+	cfg.BindStruct("accounts", &legacy)
+
+	for _, acc := range legacy {
+		if _, err := Repo.Get(acc.ID); err == nil {
+			// Already moved by a previous run that crashed before the config was written.
+			continue
+		}
+
+		if _, err := Repo.Add(acc); err != nil {
+			return err
+		}
+	}
+
+	data := cfg.Data()
+	delete(data, "accounts")
+	cfg.SetData(data)
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package accounts
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/f-taxes/kraken_import/conf"
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/vault"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrNotFound = errors.New("account not found")
+	ErrConflict = errors.New("account has been modified in the meantime")
+)
+
+// Repo is the repository used by the web and ctl servers.
+var Repo *Repository
+
+// Repository stores the accounts in their own file. Credentials are encrypted by the vault before they are written
+// and decrypted when they are read, so callers always work with plaintext accounts.
+// Every write replaces the file atomically and is serialized with all other writes.
+type Repository struct {
+	mu   sync.Mutex
+	path string
+}
+
+type document struct {
+	Accounts []g.Account `json:"accounts"`
+}
+
+// Open returns a repository for the accounts file at path. The file is created on the first write.
+func Open(path string) *Repository {
+	return &Repository{path: path}
+}
+
+// List returns all accounts.
+func (r *Repository) List() ([]g.Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return doc.Accounts, nil
+}
+
+// Get returns the account with the given id.
+func (r *Repository) Get(id string) (g.Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc, err := r.read()
+	if err != nil {
+		return g.Account{}, err
+	}
+
+	idx := doc.index(id)
+	if idx == -1 {
+		return g.Account{}, ErrNotFound
+	}
+
+	return doc.Accounts[idx], nil
+}
+
+// Find returns the account with the given id or, if there is none, the account with the given label.
+func (r *Repository) Find(idOrLabel string) (g.Account, error) {
+	list, err := r.List()
+	if err != nil {
+		return g.Account{}, err
+	}
+
+	for _, acc := range list {
+		if acc.ID == idOrLabel {
+			return acc, nil
+		}
+	}
+
+	for _, acc := range list {
+		if strings.EqualFold(acc.Label, idOrLabel) {
+			return acc, nil
+		}
+	}
+
+	return g.Account{}, ErrNotFound
+}
+
+// Add stores a new account. An id is generated if the account doesn't have one yet.
+func (r *Repository) Add(acc g.Account) (g.Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc, err := r.read()
+	if err != nil {
+		return acc, err
+	}
+
+	if acc.ID == "" {
+		acc.ID = primitive.NewObjectID().Hex()
+	}
+
+	acc.Version = 1
+	doc.Accounts = append(doc.Accounts, acc)
+
+	return acc, r.write(doc)
+}
+
+// Update replaces an existing account. acc.Version must match the stored version, otherwise ErrConflict is returned
+// and nothing is written. The returned account carries the new version.
+func (r *Repository) Update(acc g.Account) (g.Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc, err := r.read()
+	if err != nil {
+		return acc, err
+	}
+
+	idx := doc.index(acc.ID)
+	if idx == -1 {
+		return acc, ErrNotFound
+	}
+
+	if doc.Accounts[idx].Version != acc.Version {
+		return acc, ErrConflict
+	}
+
+	acc.Version++
+	doc.Accounts[idx] = acc
+
+	return acc, r.write(doc)
+}
+
+// SetLastFetched records when an account has been fetched successfully. The fetch time only ever moves forward,
+// timestamps older than the stored one are ignored.
+func (r *Repository) SetLastFetched(id string, ts time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc, err := r.read()
+	if err != nil {
+		return err
+	}
+
+	idx := doc.index(id)
+	if idx == -1 {
+		return ErrNotFound
+	}
+
+	if prev, err := time.Parse(time.RFC3339Nano, doc.Accounts[idx].LastFetched); err == nil && !ts.After(prev) {
+		return nil
+	}
+
+	doc.Accounts[idx].LastFetched = ts.UTC().Format(time.RFC3339Nano)
+	doc.Accounts[idx].Version++
+
+	return r.write(doc)
+}
+
+// Remove deletes the account with the given id.
+func (r *Repository) Remove(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc, err := r.read()
+	if err != nil {
+		return err
+	}
+
+	idx := doc.index(id)
+	if idx == -1 {
+		return ErrNotFound
+	}
+
+	doc.Accounts = append(doc.Accounts[:idx], doc.Accounts[idx+1:]...)
+
+	return r.write(doc)
+}
+
+func (d *document) index(id string) int {
+	for i := range d.Accounts {
+		if d.Accounts[i].ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (r *Repository) read() (*document, error) {
+	doc := &document{Accounts: []g.Account{}}
+
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return doc, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+
+	for i := range doc.Accounts {
+		doc.Accounts[i], err = vault.OpenAccount(doc.Accounts[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+func (r *Repository) write(doc *document) error {
+	sealed := document{Accounts: make([]g.Account, len(doc.Accounts))}
+
+	for i := range doc.Accounts {
+		acc, err := vault.SealAccount(doc.Accounts[i])
+		if err != nil {
+			return err
+		}
+		sealed.Accounts[i] = acc
+	}
+
+	data, err := json.MarshalIndent(sealed, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return conf.WriteFileAtomic(r.path, data, 0600)
+}
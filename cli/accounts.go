@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/f-taxes/kraken_import/accounts"
+	"github.com/f-taxes/kraken_import/fetcher"
+	g "github.com/f-taxes/kraken_import/global"
+	"golang.org/x/term"
+)
+
+const accountsUsage = "accounts list | add --label <label> --key <key> [--otp-type static|totp] [--force] | remove <account>"
+
+// The api secret and the two-factor password of accounts add are read from these environment variables, so they
+// don't end up in the shell history or the process list. If they aren't set, they are prompted for on a terminal
+// or read line by line from stdin.
+const (
+	SecretEnv = "KRAKEN_IMPORT_API_SECRET"
+	OtpEnv    = "KRAKEN_IMPORT_OTP"
+)
+
+func init() {
+	register("accounts", accountsUsage, runAccounts)
+}
+
+func runAccounts(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", accountsUsage)
+	}
+
+	switch args[0] {
+	case "list":
+		return listAccounts()
+	case "add":
+		return addAccount(ctx, args[1:])
+	case "remove":
+		return removeAccount(args[1:])
+	}
+
+	return fmt.Errorf("usage: %s", accountsUsage)
+}
+
+func listAccounts() error {
+	list, err := accounts.Repo.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tLABEL\tKEY\tLAST FETCHED")
+
+	for _, acc := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s…\t%s\n", acc.ID, acc.Label, acc.Masked().ApiKey, formatLastFetched(acc.LastFetched))
+	}
+
+	return w.Flush()
+}
+
+func addAccount(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("accounts add", flag.ContinueOnError)
+	acc := g.Account{}
+	fs.StringVar(&acc.Label, "label", "", "Name of the account.")
+	fs.StringVar(&acc.Notes, "notes", "", "Optional notes.")
+	fs.StringVar(&acc.ApiKey, "key", "", "API key.")
+	fs.StringVar(&acc.OtpType, "otp-type", "", "Two-factor password of the key: static or totp. The password or the secret of the authenticator app is read like the api secret.")
+	force := fs.Bool("force", false, "Save the account even if required permissions are missing.")
+
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	if acc.Label == "" || acc.ApiKey == "" {
+		return fmt.Errorf("--label and --key are required")
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+
+	var err error
+	if acc.ApiSecret, err = readSecret(stdin, SecretEnv, "API secret: "); err != nil {
+		return err
+	}
+	if acc.ApiSecret == "" {
+		return fmt.Errorf("the api secret is required, set %s or enter it when asked", SecretEnv)
+	}
+
+	if acc.OtpType != "" {
+		if acc.Otp, err = readSecret(stdin, OtpEnv, "Two-factor password or authenticator secret: "); err != nil {
+			return err
+		}
+		if acc.Otp == "" {
+			return fmt.Errorf("--otp-type needs the password in %s or entered when asked", OtpEnv)
+		}
+	}
+
+	probe := fetcher.ProbeKey(ctx, acc)
+	if !probe.Valid {
+		return fmt.Errorf("the key was rejected: %s", probe.Message)
+	}
+
+	for _, c := range probe.Permissions {
+		if !c.Granted {
+			fmt.Fprintf(os.Stderr, "Missing permission \"%s\" (required: %t). %s\n", c.Permission, c.Required, c.Message)
+		}
+	}
+
+	if probe.MissingRequired() && !*force {
+		return fmt.Errorf("the key lacks required permissions, use --force to save it anyway")
+	}
+
+	added, err := accounts.Repo.Add(acc)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Added account %s (%s).\n", added.Label, added.ID)
+	return nil
+}
+
+// readSecret returns the value of the environment variable env. If it isn't set, the value is prompted for without
+// echo on a terminal or read as the next line of stdin otherwise.
+func readSecret(stdin *bufio.Reader, env, prompt string) (string, error) {
+	if v := os.Getenv(env); v != "" {
+		return v, nil
+	}
+
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		v, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return strings.TrimSpace(string(v)), err
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read the %s from stdin: %w", strings.TrimSuffix(prompt, ": "), err)
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+func removeAccount(args []string) error {
+	if err := requireArgs(args, 1, "accounts remove <account>"); err != nil {
+		return err
+	}
+
+	acc, err := accounts.Repo.Find(args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := accounts.Repo.Remove(acc.ID); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed account %s (%s).\n", acc.Label, acc.ID)
+	return nil
+}
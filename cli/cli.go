@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{}
+
+func register(name, usage string, run func(ctx context.Context, args []string) error) {
+	commands[name] = command{usage: usage, run: run}
+}
+
+// Run executes the subcommand in args[0] and returns the exit code of the process.
+func Run(ctx context.Context, args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command \"%s\".\n\n", args[0])
+		Usage()
+		return 2
+	}
+
+	if err := cmd.run(ctx, args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 2
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+
+	return 0
+}
+
+// Usage prints the available subcommands.
+func Usage() {
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	for _, name := range []string{"accounts", "fetch", "import-csv", "export", "reconcile", "rebuild", "preview", "prices"} {
+		if cmd, ok := commands[name]; ok {
+			fmt.Fprintf(os.Stderr, "  %s\n", cmd.usage)
+		}
+	}
+}
+
+// parseArgs parses the flags of a subcommand and returns its positional arguments.
+// Unlike flag.Parse it also accepts flags after positional arguments ("fetch main --since 2023-01-01").
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// timeFlag registers a flag that accepts a date (2006-01-02) or a RFC3339 timestamp.
+func timeFlag(fs *flag.FlagSet, name, usage string) *time.Time {
+	t := &time.Time{}
+
+	fs.Func(name, usage, func(v string) error {
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+			if parsed, err := time.Parse(layout, v); err == nil {
+				*t = parsed.UTC()
+				return nil
+			}
+		}
+
+		return fmt.Errorf("expected a date like 2006-01-02 or a RFC3339 timestamp")
+	})
+
+	return t
+}
+
+func requireArgs(args []string, n int, usage string) error {
+	if len(args) != n {
+		return fmt.Errorf("usage: %s", usage)
+	}
+
+	return nil
+}
+
+func formatLastFetched(v string) string {
+	if strings.TrimSpace(v) == "" {
+		return "never"
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return v
+	}
+
+	return ts.Local().Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/f-taxes/kraken_import/accounts"
+	"github.com/f-taxes/kraken_import/fetcher"
+	"github.com/f-taxes/kraken_import/outbox"
+	"github.com/f-taxes/kraken_import/preview"
+	"github.com/f-taxes/kraken_import/sink"
+)
+
+func init() {
+	register("fetch", "fetch <account> [--since <date>] [--until <date>] [--dry-run]", runFetch)
+	register("import-csv", "import-csv <account> --ledgers <ledgers.csv> [--trades <trades.csv>] [--dry-run]", runImportCSV)
+	register("export", "export <account> --out <file> [--format jsonl|csv] [--since <date>] [--until <date>]", runExport)
+	register("rebuild", "rebuild <account>", runRebuild)
+}
+
+func runFetch(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	since := timeFlag(fs, "since", "Fetch records from this point in time on instead of continuing after the last fetch.")
+	until := timeFlag(fs, "until", "Fetch records up to this point in time.")
+	dryRun := fs.Bool("dry-run", false, "Keep the records in a preview instead of submitting them.")
+
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if err := requireArgs(args, 1, "fetch <account> [--since <date>] [--until <date>] [--dry-run]"); err != nil {
+		return err
+	}
+
+	acc, err := accounts.Repo.Find(args[0])
+	if err != nil {
+		return err
+	}
+
+	w := fetcher.Window{Since: *since, Until: *until}
+
+	if *dryRun {
+		b, err := fetcher.Preview(ctx, preview.Default, acc, w)
+		if err != nil {
+			return err
+		}
+		return printPreview(b.ID)
+	}
+
+	return fetcher.Run(ctx, outbox.Default, acc, w)
+}
+
+func runImportCSV(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import-csv", flag.ContinueOnError)
+	ledgers := fs.String("ledgers", "", "The ledgers.csv export of kraken.com.")
+	trades := fs.String("trades", "", "The trades.csv export of kraken.com.")
+	dryRun := fs.Bool("dry-run", false, "Keep the records in a preview instead of submitting them.")
+
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if err := requireArgs(args, 1, "import-csv <account> --ledgers <ledgers.csv> [--trades <trades.csv>] [--dry-run]"); err != nil {
+		return err
+	}
+
+	if *ledgers == "" {
+		return fmt.Errorf("--ledgers is required")
+	}
+
+	acc, err := accounts.Repo.Find(args[0])
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		b, err := fetcher.PreviewCSV(ctx, preview.Default, acc, *ledgers, *trades)
+		if err != nil {
+			return err
+		}
+		return printPreview(b.ID)
+	}
+
+	return fetcher.ImportCSV(ctx, outbox.Default, acc, *ledgers, *trades)
+}
+
+// runExport fetches the records of an account straight into a file. Neither the outbox nor the account's fetch time are touched.
+func runExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := fs.String("out", "", "File the records are written to.")
+	format := fs.String("format", "jsonl", "Format of the file: jsonl or csv.")
+	since := timeFlag(fs, "since", "Export records from this point in time on.")
+	until := timeFlag(fs, "until", "Export records up to this point in time.")
+
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if err := requireArgs(args, 1, "export <account> --out <file> [--format jsonl|csv] [--since <date>] [--until <date>]"); err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return fmt.Errorf("--out is required")
+	}
+
+	if *format != "jsonl" && *format != "csv" {
+		return fmt.Errorf("unknown format \"%s\", use jsonl or csv", *format)
+	}
+
+	acc, err := accounts.Repo.Find(args[0])
+	if err != nil {
+		return err
+	}
+
+	fileFormat := sink.FormatJSONL
+	if *format == "csv" {
+		fileFormat = sink.FormatCSV
+	}
+
+	s, err := sink.NewFile(*out, fileFormat)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	f, err := fetcher.New(ctx, acc, s)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Fetch(ctx, *since, *until); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported the records of %s to %s.\n", acc.Label, *out)
+	return nil
+}
+
+// runRebuild drops the cached api responses of an account and submits its complete history again.
+func runRebuild(ctx context.Context, args []string) error {
+	if err := requireArgs(args, 1, "rebuild <account>"); err != nil {
+		return err
+	}
+
+	acc, err := accounts.Repo.Find(args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := fetcher.ClearCache(acc); err != nil {
+		return err
+	}
+
+	return fetcher.Run(ctx, outbox.Default, acc, fetcher.Window{Since: time.Unix(0, 0)})
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/f-taxes/kraken_import/outbox"
+	"github.com/f-taxes/kraken_import/preview"
+)
+
+const previewUsage = "preview list | show <id> | commit <id> | discard <id>"
+
+func init() {
+	register("preview", previewUsage, runPreview)
+}
+
+func runPreview(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", previewUsage)
+	}
+
+	if args[0] == "list" {
+		return listPreviews()
+	}
+
+	if err := requireArgs(args, 2, previewUsage); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "show":
+		return printPreview(args[1])
+	case "commit":
+		if err := preview.Default.Commit(ctx, outbox.Default, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Committed preview %s.\n", args[1])
+		return nil
+	case "discard":
+		if err := preview.Default.Discard(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Discarded preview %s.\n", args[1])
+		return nil
+	}
+
+	return fmt.Errorf("usage: %s", previewUsage)
+}
+
+func listPreviews() error {
+	list, err := preview.Default.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tACCOUNT\tSOURCE\tCREATED\tRECORDS\tCOMPLETE")
+
+	for _, b := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%t\n", b.ID, b.AccountLabel, b.Source, b.Created.Local().Format("2006-01-02 15:04:05"), b.Records, b.Complete)
+	}
+
+	return w.Flush()
+}
+
+// printPreview prints the counts and warnings of a preview.
+func printPreview(id string) error {
+	page, err := preview.Default.Records(id, preview.Filter{WarningsOnly: true})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Preview %s of %s: %d records, %d with warnings.\n", id, page.Batch.AccountLabel, page.Batch.Records, page.Warnings)
+
+	keys := make([]string, 0, len(page.Counts))
+	for k := range page.Counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Printf("  %-12s %d\n", k, page.Counts[k])
+	}
+
+	for _, r := range page.Rows {
+		for _, msg := range r.Warnings {
+			fmt.Printf("  ! %s %s: %s\n", r.Kind, r.TxID, msg)
+		}
+	}
+
+	fmt.Printf("Run \"preview commit %s\" to submit the records or \"preview discard %s\" to drop them.\n", id, id)
+	return nil
+}
@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/f-taxes/kraken_import/prices"
+	"github.com/f-taxes/kraken_import/store"
+)
+
+const pricesUsage = "prices list | backfill <pair> --since <date> [--until <date>] [--interval <minutes>] | gaps <pair> --since <date> [--until <date>] [--interval <minutes>] [--min-gap <duration>] | import <archive.zip|dir|file.csv>"
+
+func init() {
+	register("prices", pricesUsage, runPrices)
+}
+
+func runPrices(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", pricesUsage)
+	}
+
+	c, err := prices.Default(ctx, store.DB)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		return listPrices(c)
+	case "backfill", "gaps":
+		return runPriceRange(ctx, c, args[0], args[1:])
+	case "import":
+		if err := requireArgs(args[1:], 1, "prices import <archive.zip|dir|file.csv>"); err != nil {
+			return err
+		}
+
+		res, err := prices.ImportArchive(c.Market(), c.Source().History(), args[1], func(file string) {
+			fmt.Printf("Importing %s\n", file)
+		})
+		if res != nil {
+			fmt.Printf("Imported %d candles from %d files, skipped %d files of unknown pairs.\n", res.Candles, res.Files, len(res.Skipped))
+		}
+		return err
+	}
+
+	return fmt.Errorf("usage: %s", pricesUsage)
+}
+
+func listPrices(c *prices.Converter) error {
+	stored, err := c.Source().History().Stored()
+	if err != nil {
+		return err
+	}
+
+	pairs := make([]string, 0, len(stored))
+	for p := range stored {
+		pairs = append(pairs, p)
+	}
+	sort.Strings(pairs)
+
+	if len(pairs) == 0 {
+		fmt.Println("The price history is empty.")
+	}
+
+	for _, p := range pairs {
+		fmt.Printf("%-12s %-12s intervals %v\n", p, c.Market().PairName(p), stored[p])
+	}
+
+	return nil
+}
+
+func runPriceRange(ctx context.Context, c *prices.Converter, cmd string, args []string) error {
+	usage := "prices " + cmd + " <pair> --since <date> [--until <date>] [--interval <minutes>]"
+
+	fs := flag.NewFlagSet("prices "+cmd, flag.ContinueOnError)
+	since := timeFlag(fs, "since", "Start of the time span.")
+	until := timeFlag(fs, "until", "End of the time span. Defaults to now.")
+	interval := fs.Int("interval", 60, "Candle interval in minutes (1, 5, 15, 30, 60, 240, 1440, 10080 or 21600).")
+	minGap := fs.Duration("min-gap", 24*time.Hour, "Only report gaps longer than this.")
+
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if err := requireArgs(args, 1, usage); err != nil {
+		return err
+	}
+
+	if since.IsZero() {
+		return fmt.Errorf("usage: %s", usage)
+	}
+
+	if until.IsZero() {
+		*until = time.Now().UTC()
+	}
+
+	pair, ok := c.Market().FindPair(args[0])
+	if !ok {
+		return fmt.Errorf("pair %s isn't traded on kraken", args[0])
+	}
+
+	h := c.Source().History()
+
+	if cmd == "backfill" {
+		n, err := c.Source().Backfill(ctx, pair, *interval, *since, *until, func(reached time.Time) {
+			fmt.Printf("\r%s reached %s", c.Market().PairName(pair), reached.Format("2006-01-02 15:04"))
+		})
+		fmt.Printf("\nStored %d candles of %s.\n", n, c.Market().PairName(pair))
+		if err != nil {
+			return err
+		}
+	}
+
+	gaps, err := h.Gaps(pair, *interval, *since, *until, *minGap)
+	if err != nil {
+		return err
+	}
+
+	if len(gaps) == 0 {
+		fmt.Printf("No gaps longer than %s.\n", *minGap)
+	}
+
+	for _, g := range gaps {
+		fmt.Printf("Gap from %s to %s (%s)\n", g.From.Format("2006-01-02 15:04"), g.To.Format("2006-01-02 15:04"), g.To.Sub(g.From).Round(time.Minute))
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/f-taxes/kraken_import/accounts"
+	"github.com/f-taxes/kraken_import/fetcher"
+	"github.com/f-taxes/kraken_import/outbox"
+	"github.com/f-taxes/kraken_import/reconcile"
+)
+
+const reconcileUsage = "reconcile <account> [--since <date>] [--until <date>] [--report-only]"
+
+func init() {
+	register("reconcile", reconcileUsage, runReconcile)
+}
+
+func runReconcile(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	since := timeFlag(fs, "since", "Compare records from this point in time on. Defaults to the beginning of the account's history.")
+	until := timeFlag(fs, "until", "Compare records up to this point in time. Defaults to the last fetch.")
+	reportOnly := fs.Bool("report-only", false, "Only report differences, don't resubmit missing records.")
+
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if err := requireArgs(args, 1, reconcileUsage); err != nil {
+		return err
+	}
+
+	acc, err := accounts.Repo.Find(args[0])
+	if err != nil {
+		return err
+	}
+
+	report, err := reconcile.Run(ctx, outbox.Default, acc, fetcher.Window{Since: *since, Until: *until}, !*reportOnly)
+	if report != nil {
+		printReport(report)
+	}
+
+	return err
+}
+
+func printReport(r *reconcile.Report) {
+	fmt.Printf("%s, %s to %s: %d records from Kraken, %d in f-taxes.\n", r.Account, r.From.Format("2006-01-02 15:04:05"), r.To.Format("2006-01-02 15:04:05"), r.Local, r.Core)
+
+	if len(r.Missing) > 0 {
+		fmt.Printf("Missing in f-taxes (%d, %d resubmitted):\n", len(r.Missing), r.Resubmitted)
+		for _, e := range r.Missing {
+			fmt.Printf("  %s %s %s %s %s %s\n", e.Ts.Format("2006-01-02 15:04:05"), e.Kind, e.TxID, e.Action, e.Amount, e.Asset)
+		}
+	}
+
+	if len(r.Extra) > 0 {
+		fmt.Printf("Unknown to Kraken (%d):\n", len(r.Extra))
+		for _, e := range r.Extra {
+			fmt.Printf("  %s %s %s %s %s %s\n", e.Ts.Format("2006-01-02 15:04:05"), e.Kind, e.TxID, e.Action, e.Amount, e.Asset)
+		}
+	}
+
+	if len(r.Missing) == 0 && len(r.Extra) == 0 {
+		fmt.Println("Everything is in sync.")
+	}
+}
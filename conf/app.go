@@ -3,6 +3,8 @@ package conf
 import (
 	"bytes"
 	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/gookit/config/v2"
 	"github.com/gookit/config/v2/yaml"
@@ -12,6 +14,9 @@ import (
 var appCfgPath string
 var App *config.Config
 
+// writeMu serializes writes of the config file.
+var writeMu sync.Mutex
+
 func LoadAppConfig(path string) {
 	cfg := config.New("appCfg", config.ParseTime)
 	App = cfg
@@ -25,6 +30,9 @@ func LoadAppConfig(path string) {
 }
 
 func WriteAppConfig() {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
 	buf := new(bytes.Buffer)
 
 	_, err := App.DumpTo(buf, config.Yaml)
@@ -32,8 +40,40 @@ func WriteAppConfig() {
 		golog.Fatalf("Failed to dump config into buffer: %v", err)
 	}
 
-	err = os.WriteFile(appCfgPath, buf.Bytes(), 0755)
+	err = WriteFileAtomic(appCfgPath, buf.Bytes(), 0600)
 	if err != nil {
 		golog.Fatalf("Failed to write config file: %v", err)
 	}
 }
+
+// WriteFileAtomic writes data to a temporary file next to path and renames it to path once it has been
+// flushed to disk. Readers either see the old or the new content, never a partially written file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,53 @@
+package conf
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gookit/config/v2"
+	"github.com/kataras/golog"
+)
+
+// Migration upgrades the app config from schema version Version-1 to Version.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(cfg *config.Config) error
+}
+
+var migrations = []Migration{}
+
+// RegisterMigration adds a schema migration. Packages that own a part of the config register their migrations in init.
+func RegisterMigration(m Migration) {
+	migrations = append(migrations, m)
+}
+
+// SchemaVersion returns the schema version of the loaded config. Configs written before versioning was introduced have version 0.
+func SchemaVersion() int {
+	return App.Int("schemaVersion", 0)
+}
+
+// Migrate applies all migrations newer than the config's schema version in order and writes the config after each of them,
+// so that a crash in between doesn't apply a migration twice.
+func Migrate() error {
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for _, m := range migrations {
+		if m.Version <= SchemaVersion() {
+			continue
+		}
+
+		golog.Infof("Migrating config to schema version %d: %s", m.Version, m.Description)
+
+		if err := m.Up(App); err != nil {
+			return fmt.Errorf("migration to schema version %d failed: %w", m.Version, err)
+		}
+
+		App.Set("schemaVersion", m.Version)
+		WriteAppConfig()
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package ctl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/grpc_client"
+	"github.com/f-taxes/kraken_import/prices"
+	pb "github.com/f-taxes/kraken_import/proto"
+	"github.com/f-taxes/kraken_import/store"
+)
+
+func (s *PluginCtl) ConvertPricesInTrade(ctx context.Context, job *pb.TradeConversionJob) (*pb.Trade, error) {
+	c, err := prices.Default(ctx, store.DB)
+	if err != nil {
+		logConversionErr(job.Trade.TxID, err)
+		return nil, err
+	}
+
+	if err := c.ConvertTrade(ctx, job.Trade, job.TargetCurrency); err != nil {
+		logConversionErr(job.Trade.TxID, err)
+		return nil, err
+	}
+
+	return job.Trade, nil
+}
+
+func (s *PluginCtl) ConvertPricesInTransfer(ctx context.Context, job *pb.TransferConversionJob) (*pb.Transfer, error) {
+	c, err := prices.Default(ctx, store.DB)
+	if err != nil {
+		logConversionErr(job.Transfer.TxID, err)
+		return nil, err
+	}
+
+	if err := c.ConvertTransfer(ctx, job.Transfer, job.TargetCurrency); err != nil {
+		logConversionErr(job.Transfer.TxID, err)
+		return nil, err
+	}
+
+	return job.Transfer, nil
+}
+
+func logConversionErr(txID string, err error) {
+	grpc_client.GrpcClient.AppLog(context.Background(), &pb.AppLogMsg{
+		Level:   pb.LogLevel_ERR,
+		Message: fmt.Sprintf("[%s] Failed to convert prices of record %s: %v", global.Plugin.Label, txID, err),
+	})
+}
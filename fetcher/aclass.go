@@ -0,0 +1,90 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/f-taxes/kraken_import/conf"
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/grpc_client"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/kataras/golog"
+)
+
+// AssetClass describes how trades of assets of a class (krakens aclass) are reported to f-taxes.
+type AssetClass struct {
+	Physical   bool `mapstructure:"physical" json:"physical"`     // The asset itself is owned.
+	Derivative bool `mapstructure:"derivative" json:"derivative"` // The asset only tracks the price of something else.
+}
+
+// assetClasses are the classes kraken uses for assets and pairs. Tokenized stocks and ETFs (xStocks) are
+// tracker certificates, so they are reported as derivatives. The table can be extended and overridden
+// in the config under "assetClasses".
+var assetClasses = map[string]AssetClass{
+	"currency":        {Physical: true},
+	"forex":           {Physical: true},
+	"equity":          {Physical: true},
+	"tokenized_asset": {Derivative: true},
+}
+
+// warnedClasses remembers the unknown classes that have been reported already, so each is only reported once.
+var warnedClasses sync.Map
+
+// assetClassOverrides returns the asset classes the user added or overrode in the config.
+func assetClassOverrides() map[string]AssetClass {
+	overrides := map[string]AssetClass{}
+	if conf.App != nil {
+		if err := conf.App.MapOnExists("assetClasses", &overrides); err != nil {
+			golog.Errorf("Failed to read the asset classes from the config: %v", err)
+		}
+	}
+
+	return overrides
+}
+
+// assetClass returns how an asset class is reported, with the user's overrides (see assetClassOverrides) taking
+// precedence. Unknown classes are treated as currencies and reported.
+func assetClass(name string, overrides map[string]AssetClass) AssetClass {
+	if name == "" {
+		name = "currency"
+	}
+
+	if c, ok := overrides[name]; ok {
+		return c
+	}
+
+	if c, ok := assetClasses[name]; ok {
+		return c
+	}
+
+	if _, warned := warnedClasses.LoadOrStore(name, true); !warned {
+		grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_WARN, Message: fmt.Sprintf("[%s] Kraken uses the unknown asset class \"%s\". Its trades are reported like currencies, add it to \"assetClasses\" in the config to change that.", g.Plugin.Label, name)})
+	}
+
+	return assetClasses["currency"]
+}
+
+// tradeProps derives the properties of a trade from the classes of the pair's base and quote asset.
+// The base asset is what's being traded, so its class decides. The quote's class is only checked to report
+// classes the plugin doesn't know.
+func tradeProps(pair PairInfo, base, quote AssetInfo, isMargin bool, classes map[string]AssetClass) *proto.TradeProps {
+	baseClass := pair.AclassBase
+	if baseClass == "" {
+		baseClass = base.Aclass
+	}
+
+	quoteClass := pair.AclassQuote
+	if quoteClass == "" {
+		quoteClass = quote.Aclass
+	}
+
+	c := assetClass(baseClass, classes)
+	assetClass(quoteClass, classes)
+
+	return &proto.TradeProps{
+		IsMarginTrade: isMargin,
+		IsPhysical:    c.Physical,
+		IsDerivative:  c.Derivative,
+	}
+}
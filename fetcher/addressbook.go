@@ -0,0 +1,143 @@
+package fetcher
+
+import (
+	"context"
+	"sort"
+
+	"github.com/dgraph-io/badger/v4"
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/krakenapi"
+	"github.com/f-taxes/kraken_import/store"
+)
+
+const addressBookPrefix = "addressbook:"
+
+// SavedAddress is a withdrawal address saved in a Kraken account, together with the label the user gave it in f-taxes.
+type SavedAddress struct {
+	krakenapi.WithdrawAddress
+	Label string `json:"label"` // Account or wallet in f-taxes the address belongs to.
+}
+
+// AddressLabel is the label of the withdrawal address saved under Key for Asset. The same key can be used for
+// addresses of several assets.
+type AddressLabel struct {
+	Asset string `json:"asset"`
+	Key   string `json:"key"`
+	Label string `json:"label"`
+}
+
+// addressID identifies a saved address by its asset and its key or address.
+func addressID(asset, keyOrAddress string) string {
+	return asset + ":" + keyOrAddress
+}
+
+// AddressBook returns the withdrawal addresses of an account as they were stored by the last SyncAddressBook.
+func AddressBook(accountID string) ([]SavedAddress, error) {
+	book := []SavedAddress{}
+
+	err := store.DB.View(func(txn *badger.Txn) error {
+		return store.GetJSON(txn, addressBookPrefix+accountID, &book)
+	})
+	if err != nil && !store.IsNotFound(err) {
+		return nil, err
+	}
+
+	return book, nil
+}
+
+// SyncAddressBook loads the withdrawal addresses of an account from Kraken and stores them. Labels of addresses that
+// were known before are kept.
+func SyncAddressBook(ctx context.Context, acc g.Account) ([]SavedAddress, error) {
+	resp, err := newKrakenApi(acc).WithdrawAddresses(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	known, err := AddressBook(acc.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{}
+	for _, a := range known {
+		labels[addressID(a.Asset, a.Key)] = a.Label
+	}
+
+	book := []SavedAddress{}
+	for _, a := range *resp {
+		book = append(book, SavedAddress{WithdrawAddress: a, Label: labels[addressID(a.Asset, a.Key)]})
+	}
+
+	sort.Slice(book, func(i, j int) bool {
+		if book[i].Asset != book[j].Asset {
+			return book[i].Asset < book[j].Asset
+		}
+		return book[i].Key < book[j].Key
+	})
+
+	return book, putAddressBook(acc.ID, book)
+}
+
+// SetAddressLabels sets the labels of the withdrawal addresses of an account by their asset and key.
+// Addresses that aren't in labels lose their label.
+func SetAddressLabels(accountID string, labels []AddressLabel) ([]SavedAddress, error) {
+	book, err := AddressBook(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[string]string{}
+	for _, l := range labels {
+		byID[addressID(l.Asset, l.Key)] = l.Label
+	}
+
+	for i := range book {
+		book[i].Label = byID[addressID(book[i].Asset, book[i].Key)]
+	}
+
+	return book, putAddressBook(accountID, book)
+}
+
+func putAddressBook(accountID string, book []SavedAddress) error {
+	return store.DB.Update(func(txn *badger.Txn) error {
+		return store.PutJSON(txn, addressBookPrefix+accountID, book)
+	})
+}
+
+// addressLabels maps the keys and addresses of the labeled withdrawal addresses of an account to their label, each
+// together with the asset (see addressID).
+func addressLabels(accountID string) map[string]string {
+	labels := map[string]string{}
+
+	if store.DB == nil {
+		return labels
+	}
+
+	book, err := AddressBook(accountID)
+	if err != nil {
+		return labels
+	}
+
+	for _, a := range book {
+		if a.Label == "" {
+			continue
+		}
+		if a.Key != "" {
+			labels[addressID(a.Asset, a.Key)] = a.Label
+		}
+		if a.Address != "" {
+			labels[addressID(a.Asset, a.Address)] = a.Label
+		}
+	}
+
+	return labels
+}
+
+// destinationLabel returns the label of the address a withdrawal was sent to, if the user labeled it.
+func (f *Fetcher) destinationLabel(st krakenapi.TransferStatus) string {
+	if label, ok := f.addresses[addressID(st.Asset, st.Key)]; ok {
+		return label
+	}
+
+	return f.addresses[addressID(st.Asset, st.Info)]
+}
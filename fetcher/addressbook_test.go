@@ -0,0 +1,71 @@
+package fetcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/f-taxes/kraken_import/conf"
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/krakenapi"
+	"github.com/f-taxes/kraken_import/store"
+)
+
+func TestAddressLabels(t *testing.T) {
+	f, s := setupFetcher(t, "Kraken")
+	f.restClient = replayApi(t, map[string]string{
+		"/0/private/WithdrawStatus": "testdata/withdraw_status.json",
+	})
+
+	// Labels are applied even if transfers aren't enriched.
+	if err := conf.App.Set("transfers.enrich", false); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := store.DB
+	store.DB = db
+	t.Cleanup(func() {
+		store.DB = prev
+		db.Close()
+	})
+
+	// The same key is saved for two assets.
+	book := []SavedAddress{
+		{WithdrawAddress: krakenapi.WithdrawAddress{Asset: "XETH", Key: "hardware wallet", Address: "0x71C7656EC7ab88b098defB751B7401B5f6d8976F"}},
+		{WithdrawAddress: krakenapi.WithdrawAddress{Asset: "XXBT", Key: "hardware wallet", Address: "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"}},
+	}
+	if err := putAddressBook("acc", book); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SetAddressLabels("acc", []AddressLabel{
+		{Asset: "XETH", Key: "hardware wallet", Label: "Ledger ETH"},
+		{Asset: "XXBT", Key: "hardware wallet", Label: "Ledger BTC"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	f.addresses = addressLabels("acc")
+
+	recs := readLedger(t, `[
+		{"refid": "FTQcuak-YdFnnW4vgCFaDjCFtGw3Kk", "time": 1688020100, "type": "withdrawal", "aclass": "currency", "asset": "XETH", "amount": "-1.2500000000", "fee": "0.0005000000", "ID": "L-WITHDRAWAL"}
+	]`)
+
+	if err := f.collectLedgerRecs(context.Background(), recs, map[string][]g.LedgerRec{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.submitLedgerTransfers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.transfers) != 1 {
+		t.Fatalf("submitted %d transfers, want 1", len(s.transfers))
+	}
+	if tr := s.transfers[0]; tr.Destination != "Ledger ETH" || tr.Comment != "" {
+		t.Errorf("withdrawal went to %q with comment %q, want Ledger ETH without comment", tr.Destination, tr.Comment)
+	}
+}
@@ -0,0 +1,274 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/grpc_client"
+	"github.com/f-taxes/kraken_import/outbox"
+	"github.com/f-taxes/kraken_import/preview"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/shopspring/decimal"
+)
+
+// Timestamp layouts used by the csv exports of kraken.com.
+var csvTimeLayouts = []string{"2006-01-02 15:04:05.9999", "2006-01-02 15:04:05", "2006-01-02T15:04:05Z07:00"}
+
+// ImportCSV imports the ledgers.csv and (optionally) trades.csv exports of kraken.com into the outbox of an account.
+func ImportCSV(ctx context.Context, ob *outbox.Outbox, acc g.Account, ledgersPath, tradesPath string) error {
+	return runBatch(ctx, acc, csvWindow, outboxBatch(ctx, ob, acc), func(f *Fetcher, since, until time.Time) error {
+		return f.ImportCSV(ctx, ledgersPath, tradesPath)
+	})
+}
+
+// PreviewCSV is the dry run of ImportCSV.
+func PreviewCSV(ctx context.Context, ps *preview.Store, acc g.Account, ledgersPath, tradesPath string) (*preview.Batch, error) {
+	var pb *preview.Batch
+
+	err := runBatch(ctx, acc, csvWindow, previewBatch(ps, acc, "csv", &pb), func(f *Fetcher, since, until time.Time) error {
+		return f.ImportCSV(ctx, ledgersPath, tradesPath)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pb, nil
+}
+
+// Imported files don't move the account's fetch time, the window only ends when the next fetch starts.
+var csvWindow = Window{Since: time.Unix(0, 0)}
+
+// ImportCSV reads the ledger entries and trades of csv exports and submits them to the fetcher's sink,
+// the same way fetched entries are.
+func (f *Fetcher) ImportCSV(ctx context.Context, ledgersPath, tradesPath string) error {
+	ledgerRecs, err := f.readLedgersCSV(ledgersPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", ledgersPath, err)
+	}
+
+	spendsAndReceives := map[string][]g.LedgerRec{}
+
+	if err := f.collectLedgerRecs(ctx, ledgerRecs, spendsAndReceives); err != nil {
+		return err
+	}
+
+	count, err := f.submitLedgerTransfers(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := f.submitCardPurchases(ctx, spendsAndReceives); err != nil {
+		return err
+	}
+
+	n, err := f.submitAdjustments(ctx, f.adjustments)
+	count += n
+	if err != nil {
+		return err
+	}
+
+	n, err = f.submitReversals(ctx)
+	count += n
+	if err != nil {
+		return err
+	}
+
+	if tradesPath == "" {
+		f.logImport(count, 0)
+		return nil
+	}
+
+	tradeRecs, err := f.readTradesCSV(tradesPath, ledgerRecs)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", tradesPath, err)
+	}
+
+	f.loadOrders(ctx, tradeRecs, orderDetails())
+
+	trades := 0
+	for i := range tradeRecs {
+		ok, err := f.submitTrade(ctx, tradeRecs[i])
+		if err != nil {
+			return err
+		}
+		if ok {
+			trades++
+		}
+	}
+
+	if aggregateFills() {
+		held := f.heldFills()
+		n, err := f.flushFills(ctx)
+		if err != nil {
+			return err
+		}
+		trades += n - held
+	}
+
+	f.logImport(count, trades)
+	return nil
+}
+
+func (f *Fetcher) logImport(transfers, trades int) {
+	grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_INFO, Message: fmt.Sprintf("[%s] Imported %d transfers and %d trades into %s.", g.Plugin.Label, transfers, trades, f.label)})
+}
+
+func (f *Fetcher) readLedgersCSV(path string) ([]g.LedgerRec, error) {
+	rows, err := readCSV(path)
+	if err != nil {
+		return nil, err
+	}
+
+	recs := make([]g.LedgerRec, 0, len(rows))
+
+	for i, row := range rows {
+		ts, err := parseCSVTime(row["time"])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+2, err)
+		}
+
+		recs = append(recs, g.LedgerRec{
+			ID: row["txid"],
+			LedgerInfoDoc: g.LedgerInfoDoc{
+				RefID:   row["refid"],
+				Time:    ts,
+				Type:    row["type"],
+				Subtype: row["subtype"],
+				Aclass:  row["aclass"],
+				Asset:   f.assetKey(row["asset"]),
+				Amount:  row["amount"],
+				Fee:     row["fee"],
+				Balance: row["balance"],
+			},
+		})
+	}
+
+	return recs, nil
+}
+
+func (f *Fetcher) readTradesCSV(path string, ledgerRecs []g.LedgerRec) ([]g.TradeRec, error) {
+	rows, err := readCSV(path)
+	if err != nil {
+		return nil, err
+	}
+
+	recs := make([]g.TradeRec, 0, len(rows))
+
+	for i, row := range rows {
+		ts, err := parseCSVTime(row["time"])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+2, err)
+		}
+
+		r := g.TradeRec{ID: row["txid"]}
+		r.TransactionID = row["ordertxid"]
+		r.PostxID = row["postxid"]
+		r.AssetPair = row["pair"]
+		r.Time = ts
+		r.Type = row["type"]
+		r.OrderType = row["ordertype"]
+		r.Price = csvFloat(row["price"])
+		r.Cost = csvFloat(row["cost"])
+		r.Fee = csvFloat(row["fee"])
+		r.Volume = csvFloat(row["vol"])
+		r.Margin = csvFloat(row["margin"])
+		r.Misc = row["misc"]
+
+		if maker, err := strconv.ParseBool(row["maker"]); err == nil {
+			r.Maker = &maker
+		}
+
+		for _, id := range strings.Split(row["ledgers"], ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				r.Ledgers = append(r.Ledgers, id)
+			}
+		}
+
+		r.LedgerRecs = f.findLedgerRecs(r.Ledgers, ledgerRecs)
+		recs = append(recs, r)
+	}
+
+	return recs, nil
+}
+
+// assetKey returns the asset's key in krakens list of assets. Newer exports use the altname (e.g. XBT instead of XXBT).
+func (f *Fetcher) assetKey(name string) string {
+	if _, ok := f.assets[name]; ok {
+		return name
+	}
+
+	for key, a := range f.assets {
+		if a.Altname == name {
+			return key
+		}
+	}
+
+	return name
+}
+
+// readCSV reads a csv file with a header row and returns its rows as maps of column name to value.
+func readCSV(path string) ([]map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []map[string]string{}
+
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(rec) {
+				row[strings.ToLower(strings.TrimSpace(col))] = strings.TrimSpace(rec[i])
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func parseCSVTime(v string) (float64, error) {
+	for _, layout := range csvTimeLayouts {
+		if ts, err := time.Parse(layout, v); err == nil {
+			return float64(ts.UnixNano()) / float64(time.Second), nil
+		}
+	}
+
+	if ts, err := strconv.ParseFloat(v, 64); err == nil {
+		return ts, nil
+	}
+
+	return 0, fmt.Errorf("unsupported time format \"%s\"", v)
+}
+
+func csvFloat(v string) float64 {
+	f, _ := decimal.NewFromString(v)
+	return f.InexactFloat64()
+}
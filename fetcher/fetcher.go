@@ -2,17 +2,15 @@ package fetcher
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
 	"sort"
 	"time"
 
 	g "github.com/f-taxes/kraken_import/global"
 	"github.com/f-taxes/kraken_import/grpc_client"
+	"github.com/f-taxes/kraken_import/krakenapi"
 	"github.com/f-taxes/kraken_import/proto"
+	"github.com/f-taxes/kraken_import/sink"
 	"github.com/kataras/golog"
 	"github.com/shopspring/decimal"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -25,61 +23,75 @@ type Fetcher struct {
 	restClient *ProxyApi
 	assets     map[string]AssetInfo
 	pairs      map[string]PairInfo
-}
+	sink       sink.Sink
 
-func New(label, key, secret string) (*Fetcher, error) {
-	client := NewProxyApi(label, key, secret)
+	// orders holds orders loaded by loadOrders, by their id.
+	orders map[string]krakenapi.Order
 
-	f := &Fetcher{
-		label:      label,
-		restClient: client,
-	}
+	// fills holds back trades by order until flushFills if fills are aggregated.
+	fills map[string][]*proto.Trade
 
-	f.LoadAssets()
+	// deposits collects the deposit and withdrawal entries and adjustments collects the entries of migrations, airdrops
+	// and other adjustments until all entries are known, as the two sides of a migration or of a reversed transfer can
+	// be far apart.
+	deposits    []g.LedgerRec
+	adjustments []g.LedgerRec
 
-	return f, f.LoadPairs()
-}
+	// transferStatus holds the deposits and withdrawals loaded by loadTransferStatus, by their reference id.
+	transferStatus map[string]krakenapi.TransferStatus
+	statusDenied   map[bool]bool
 
-var limiter = ratelimit.New(8, ratelimit.Per(time.Minute))
+	// reversals holds the entries that undo a cancelled, failed or returned transfer by reference id, reversed the
+	// reference ids of the transfers that have been skipped because of that.
+	reversals map[string]g.LedgerRec
+	reversed  map[string]bool
 
-func (f *Fetcher) LoadAssets() error {
-	limiter.Take()
+	// currencies and classes hold the user's currency overrides and asset classes, loaded once per fetch.
+	currencies map[string]string
+	classes    map[string]AssetClass
 
-	resp, err := http.Get("https://api.kraken.com/0/public/Assets")
-	if err != nil {
-		return err
+	// addresses maps the asset and key or address of labeled withdrawal addresses to their label, see addressID.
+	addresses map[string]string
+}
+
+// New creates a fetcher for an account that writes the fetched records to s.
+func New(ctx context.Context, acc g.Account, s sink.Sink) (*Fetcher, error) {
+	client := NewProxyApi(acc)
+
+	f := &Fetcher{
+		label:      acc.Label,
+		restClient: client,
+		sink:       s,
+		currencies: CurrencyOverrides(),
+		classes:    assetClassOverrides(),
+		addresses:  addressLabels(acc.ID),
 	}
-	data, _ := io.ReadAll(resp.Body)
 
-	var assetResp AssetsResponse
-	err = json.Unmarshal(data, &assetResp)
-	if err != nil {
-		return err
+	if err := Reg.Refresh(ctx, false); err != nil {
+		return nil, err
 	}
 
-	f.assets = assetResp.Result
+	f.assets, f.pairs = Reg.Market()
 
-	return nil
+	return f, nil
 }
 
-func (f *Fetcher) LoadPairs() error {
-	limiter.Take()
-
-	resp, err := http.Get("https://api.kraken.com/0/public/AssetPairs")
-	if err != nil {
-		return err
-	}
-	data, _ := io.ReadAll(resp.Body)
+var limiter = ratelimit.New(8, ratelimit.Per(time.Minute))
 
-	var pairResponse PairResponse
-	err = json.Unmarshal(data, &pairResponse)
-	if err != nil {
-		return err
+// LoadMarket returns all assets and pairs known to the registry, refreshed with the live data if possible.
+func LoadMarket(ctx context.Context) (map[string]AssetInfo, map[string]PairInfo, error) {
+	if err := Reg.Refresh(ctx, false); err != nil {
+		return nil, nil, err
 	}
 
-	f.pairs = pairResponse.Result
+	assets, pairs := Reg.Market()
+	return assets, pairs, nil
+}
 
-	return nil
+// NormalizeCurrency returns the name f-taxes knows a Kraken asset by (e.g. BTC for XXBT), with the overrides
+// returned by CurrencyOverrides applied.
+func NormalizeCurrency(v string, overrides map[string]string) string {
+	return normalizeCurrency(v, overrides)
 }
 
 func (f *Fetcher) findLedgerRecs(legerIDList []string, ledgerRecs g.LedgerRecList) g.LedgerRecList {
@@ -107,21 +119,10 @@ func (f *Fetcher) ledgerRecByCurrency(currency string, ledgerRecs g.LedgerRecLis
 	return nil
 }
 
-func (f *Fetcher) Trades(lastFetched time.Time, ledgerRecs []g.LedgerRec) error {
-	lastFetched = time.Time{}
-
-	if len(ledgerRecs) == 0 {
-		d, err := os.ReadFile("ledger.json")
-		if err != nil {
-			return err
-		}
-		err = json.Unmarshal(d, &ledgerRecs)
-		if err != nil {
-			return err
-		}
-	} else {
-		d, _ := json.MarshalIndent(ledgerRecs, "", "  ")
-		os.WriteFile("ledger.json", d, 0775)
+// Trades fetches the trades between since and until (zero means up to now) and submits them to the sink.
+func (f *Fetcher) Trades(ctx context.Context, since, until time.Time, ledgerRecs []g.LedgerRec) error {
+	if err := f.learnAssets(ledgerRecs); err != nil {
+		return err
 	}
 
 	jobId := primitive.NewObjectID().Hex()
@@ -136,7 +137,12 @@ func (f *Fetcher) Trades(lastFetched time.Time, ledgerRecs []g.LedgerRec) error
 		Progress: "100",
 	})
 
-	start := lastFetched.Unix()
+	start := since.Unix()
+	end := int64(0)
+	if !until.IsZero() {
+		end = until.Unix()
+	}
+
 	seen := map[string]struct{}{}
 	count := 0
 	page := 0
@@ -148,7 +154,7 @@ outer:
 			"ledgers": "true",
 		}
 
-		resp, err := f.restClient.TradesHistory(start, 0, params)
+		resp, err := f.restClient.TradesHistory(ctx, start, end, params)
 
 		if err != nil {
 			return err
@@ -173,150 +179,149 @@ outer:
 			return recs[i].Time >= recs[j].Time
 		})
 
-		for i := range recs {
-			r := recs[i]
-			ts := time.Unix(int64(r.Time), 0).UTC()
+		f.loadOrders(ctx, recs, orderDetails())
 
-			if r.ID == "TAPYVU-AZ24B-JGVUZX" {
-				fmt.Println(r.ID)
+		for i := range recs {
+			ok, err := f.submitTrade(ctx, recs[i])
+			if err != nil {
+				return err
 			}
+			if ok {
+				count++
+			}
+		}
 
-			count++
+		grpc_client.GrpcClient.ShowJobProgress(context.Background(), &proto.JobProgress{
+			ID:       jobId,
+			Label:    fmt.Sprintf("Fetched %d trades for account \"%s\"", count, f.label),
+			Progress: "-1",
+		})
+	}
 
-			pair, ok := f.pairs[r.AssetPair]
-			if !ok {
-				grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_ERR, Message: fmt.Sprintf("[%s] Pair %s wasn't found in krakens list of pairs. This shouldn't be happening.", g.Plugin.Label, r.AssetPair)})
-				return nil
-			}
+	if aggregateFills() {
+		// The held back fills were counted above, they're replaced by the trades they are combined into.
+		held := f.heldFills()
+		n, err := f.flushFills(ctx)
+		if err != nil {
+			return err
+		}
+		count += n - held
+	}
 
-			baseAsset, ok := f.assets[pair.Base]
-			if !ok {
-				grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_ERR, Message: fmt.Sprintf("[%s] Asset %s wasn't found in krakens list of assets. This shouldn't be happening.", g.Plugin.Label, pair.Base)})
-				return nil
-			}
+	grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_INFO, Message: fmt.Sprintf("[%s] Fetched %d new trades from %s.", g.Plugin.Label, count, f.label)})
+	return nil
+}
 
-			quoteAsset, ok := f.assets[pair.Quote]
-			if !ok {
-				grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_ERR, Message: fmt.Sprintf("[%s] Asset %s wasn't found in krakens list of assets. This shouldn't be happening.", g.Plugin.Label, pair.Base)})
-				return nil
-			}
+// submitTrade turns a trade and its ledger records into a trade record. It returns false if the trade was skipped
+// because its pair or assets are unknown, even to the registry.
+func (f *Fetcher) submitTrade(ctx context.Context, r g.TradeRec) (bool, error) {
+	ts := time.Unix(int64(r.Time), 0).UTC()
+
+	pair, ok := f.pairs[r.AssetPair]
+	if !ok {
+		var err error
+		if pair, err = f.learnPair(r); err != nil {
+			grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_ERR, Message: fmt.Sprintf("[%s] Pair %s of trade %s is neither known to kraken nor could it be derived from the ledger: %v", g.Plugin.Label, r.AssetPair, r.ID, err)})
+			return false, nil
+		}
+	}
 
-			side := proto.TxAction_BUY
+	baseAsset, ok := f.assets[pair.Base]
+	if !ok {
+		grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_ERR, Message: fmt.Sprintf("[%s] Asset %s wasn't found in krakens list of assets. This shouldn't be happening.", g.Plugin.Label, pair.Base)})
+		return false, nil
+	}
 
-			if r.Type == "sell" {
-				side = proto.TxAction_SELL
-			}
+	quoteAsset, ok := f.assets[pair.Quote]
+	if !ok {
+		grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_ERR, Message: fmt.Sprintf("[%s] Asset %s wasn't found in krakens list of assets. This shouldn't be happening.", g.Plugin.Label, pair.Quote)})
+		return false, nil
+	}
 
-			orderType := proto.OrderType_TAKER
+	side := proto.TxAction_BUY
 
-			if r.OrderType == "limit" {
-				orderType = proto.OrderType_MAKER
-			}
+	if r.Type == "sell" {
+		side = proto.TxAction_SELL
+	}
 
-			baseAssetNormalized := normalizeCurrency(baseAsset.Altname)
-			quoteAssetNormalized := normalizeCurrency(quoteAsset.Altname)
-			// cost := roundByCurrency(quoteAssetNormalized, r.Cost)
-			// fee := roundByCurrency(quoteAssetNormalized, r.Fee)
-
-			// ledgerRecBase := f.ledgerRecByCurrency(pair.Base, r.LedgerRecs)
-			// ledgerRecQuote := f.ledgerRecByCurrency(pair.Quote, r.LedgerRecs)
-
-			amount := decimal.Zero
-			value := fmt.Sprintf("%f", r.Cost)
-			// fee := "0"
-			// quoteFee := fmt.Sprintf("%f", r.Fee)
-			// isMargin := false
-
-			// if ledgerRecBase != nil {
-			// 	fee = ledgerRecBase.Fee.Abs(&ledgerRecBase.Fee).String()
-			// 	isMargin = isMargin || ledgerRecBase.Type == "margin"
-			// }
-
-			// if ledgerRecQuote != nil {
-			// 	quoteFee = ledgerRecQuote.Fee.Abs(&ledgerRecQuote.Fee).String()
-			// 	isMargin = isMargin || ledgerRecQuote.Type == "margin"
-			// }
-
-			fee := decimal.Zero
-			quoteFee := decimal.Zero
-			feeDecimals := 0
-			quoteFeeDecimals := 0
-			feeCurrency := baseAssetNormalized
-			isMargin := false
-
-			for _, l := range r.LedgerRecs {
-				if l.Type == "margin" {
-					isMargin = true
-				}
-
-				switch l.Asset {
-				case pair.Base:
-					amount = amount.Add(g.StrToDecimal(l.Amount).Abs())
-					fee = fee.Add(g.StrToDecimal(l.Fee).Abs())
-					feeDecimals = f.assets[l.Asset].Decimals
-				case pair.Quote:
-					quoteFee = quoteFee.Add(g.StrToDecimal(l.Fee).Abs())
-					quoteFeeDecimals = f.assets[l.Asset].Decimals
-				default:
-					if isMargin {
-						feeCurrency = normalizeCurrency(l.Asset)
-						fee = fee.Add(g.StrToDecimal(l.Fee).Abs())
-					}
-				}
-			}
+	orderType := f.orderType(r)
 
-			if amount.IsZero() {
-				amount = decimal.NewFromFloat(r.Volume)
-			}
+	baseAssetNormalized := normalizeCurrency(baseAsset.Altname, f.currencies)
+	quoteAssetNormalized := normalizeCurrency(quoteAsset.Altname, f.currencies)
 
-			props := &proto.TradeProps{
-				IsMarginTrade: isMargin,
-				IsPhysical:    true,
-				IsDerivative:  false,
-			}
+	amount := decimal.Zero
+	value := fmt.Sprintf("%f", r.Cost)
+	fee := decimal.Zero
+	quoteFee := decimal.Zero
+	feeDecimals := 0
+	quoteFeeDecimals := 0
+	feeCurrency := baseAssetNormalized
+	isMargin := false
 
-			trade := &proto.Trade{
-				TxID:             r.ID,
-				Ts:               timestamppb.New(ts),
-				Account:          f.label,
-				Ticker:           pair.Wsname,
-				Quote:            quoteAssetNormalized,
-				Asset:            baseAssetNormalized,
-				Price:            fmt.Sprintf("%f", r.Price),
-				Amount:           amount.String(),
-				Value:            value,
-				Action:           side,
-				OrderType:        orderType,
-				OrderID:          r.TransactionID,
-				Fee:              fee.String(),
-				FeeCurrency:      feeCurrency,
-				QuoteFee:         quoteFee.String(),
-				QuoteFeeCurrency: quoteAssetNormalized,
-				AssetDecimals:    int32(baseAsset.Decimals),
-				QuoteDecimals:    int32(quoteAsset.Decimals),
-				FeeDecimals:      int32(feeDecimals),
-				QuoteFeeDecimals: int32(quoteFeeDecimals),
-				Props:            props,
-				Plugin:           g.Plugin.ID,
-				PluginVersion:    g.Plugin.Version,
-				Created:          timestamppb.New(time.Now().UTC()),
-			}
+	for _, l := range r.LedgerRecs {
+		if l.Type == "margin" {
+			isMargin = true
+		}
 
-			grpc_client.GrpcClient.SubmitTrade(context.Background(), trade)
+		switch l.Asset {
+		case pair.Base:
+			amount = amount.Add(g.StrToDecimal(l.Amount).Abs())
+			fee = fee.Add(g.StrToDecimal(l.Fee).Abs())
+			feeDecimals = f.assets[l.Asset].Decimals
+		case pair.Quote:
+			quoteFee = quoteFee.Add(g.StrToDecimal(l.Fee).Abs())
+			quoteFeeDecimals = f.assets[l.Asset].Decimals
+		default:
+			if isMargin {
+				feeCurrency = normalizeCurrency(l.Asset, f.currencies)
+				fee = fee.Add(g.StrToDecimal(l.Fee).Abs())
+			}
 		}
+	}
 
-		grpc_client.GrpcClient.ShowJobProgress(context.Background(), &proto.JobProgress{
-			ID:       jobId,
-			Label:    fmt.Sprintf("Fetched %d trades for account \"%s\"", count, f.label),
-			Progress: "-1",
-		})
+	if amount.IsZero() {
+		amount = decimal.NewFromFloat(r.Volume)
 	}
 
-	grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_INFO, Message: fmt.Sprintf("[%s] Fetched %d new trades from %s.", g.Plugin.Label, count, f.label)})
-	return nil
+	props := tradeProps(pair, baseAsset, quoteAsset, isMargin, f.classes)
+
+	trade := &proto.Trade{
+		TxID:             r.ID,
+		Ts:               timestamppb.New(ts),
+		Account:          f.label,
+		Ticker:           pair.Wsname,
+		Quote:            quoteAssetNormalized,
+		Asset:            baseAssetNormalized,
+		Price:            fmt.Sprintf("%f", r.Price),
+		Amount:           amount.String(),
+		Value:            value,
+		Action:           side,
+		OrderType:        orderType,
+		OrderID:          r.TransactionID,
+		Fee:              fee.String(),
+		FeeCurrency:      feeCurrency,
+		QuoteFee:         quoteFee.String(),
+		QuoteFeeCurrency: quoteAssetNormalized,
+		AssetDecimals:    int32(baseAsset.Decimals),
+		QuoteDecimals:    int32(quoteAsset.Decimals),
+		FeeDecimals:      int32(feeDecimals),
+		QuoteFeeDecimals: int32(quoteFeeDecimals),
+		Props:            props,
+		Plugin:           g.Plugin.ID,
+		PluginVersion:    g.Plugin.Version,
+		Created:          timestamppb.New(time.Now().UTC()),
+	}
+
+	if orderDetails() {
+		trade.Comment = f.orderComment(r.TransactionID)
+	}
+
+	return true, f.emitTrade(ctx, trade)
 }
 
-func (f *Fetcher) Ledger(lastFetched time.Time) ([]g.LedgerRec, error) {
+// Ledger fetches the ledger entries between since and until (zero means up to now) and submits the transfers
+// and credit card purchases among them to the sink. All fetched entries are returned.
+func (f *Fetcher) Ledger(ctx context.Context, since, until time.Time) ([]g.LedgerRec, error) {
 	jobId := primitive.NewObjectID().Hex()
 	grpc_client.GrpcClient.ShowJobProgress(context.Background(), &proto.JobProgress{
 		ID:       jobId,
@@ -329,21 +334,68 @@ func (f *Fetcher) Ledger(lastFetched time.Time) ([]g.LedgerRec, error) {
 		Progress: "100",
 	})
 
-	start := fmt.Sprintf("%d", lastFetched.Unix())
+	allSpendsAndReceives := map[string][]g.LedgerRec{}
+
+	allRecs, err := f.ledgerEntries(ctx, since, until, func(recs, all []g.LedgerRec) error {
+		if err := f.collectLedgerRecs(ctx, recs, allSpendsAndReceives); err != nil {
+			return err
+		}
+
+		grpc_client.GrpcClient.ShowJobProgress(context.Background(), &proto.JobProgress{
+			ID:       jobId,
+			Label:    fmt.Sprintf("Fetched %d ledger entries for account \"%s\"", len(all), f.label),
+			Progress: "-1",
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := f.submitLedgerTransfers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.submitCardPurchases(ctx, allSpendsAndReceives); err != nil {
+		return nil, err
+	}
+
+	n, err := f.submitAdjustments(ctx, f.adjustments)
+	count += n
+	if err != nil {
+		return nil, err
+	}
+
+	n, err = f.submitReversals(ctx)
+	count += n
+	if err != nil {
+		return nil, err
+	}
+
+	grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_INFO, Message: fmt.Sprintf("[%s] Fetched %d new transfers from %s.", g.Plugin.Label, count, f.label)})
+	return allRecs, nil
+}
+
+// ledgerEntries pages through the ledger entries between since and until (zero means up to now), newest first.
+// page is called with the entries of every page and all entries so far, if it is set.
+func (f *Fetcher) ledgerEntries(ctx context.Context, since, until time.Time, page func(recs, all []g.LedgerRec) error) ([]g.LedgerRec, error) {
+	start := fmt.Sprintf("%d", since.Unix())
 	seen := map[string]struct{}{}
-	count := 0
-	page := 0
+	ofs := 0
 	allRecs := []g.LedgerRec{}
-	allSpendsAndReceives := map[string][]g.LedgerRec{}
 
-outer:
 	for {
 		params := map[string]string{
 			"start": start,
-			"ofs":   fmt.Sprintf("%d", page),
+			"ofs":   fmt.Sprintf("%d", ofs),
+		}
+
+		if !until.IsZero() {
+			params["end"] = fmt.Sprintf("%d", until.Unix())
 		}
 
-		resp, err := f.restClient.Ledgers(params)
+		resp, err := f.restClient.Ledgers(ctx, params)
 
 		if err != nil {
 			return allRecs, err
@@ -359,69 +411,99 @@ outer:
 		}
 
 		if len(recs) == 0 {
-			break outer
+			return allRecs, nil
 		}
 
-		page += len(recs)
+		ofs += len(recs)
 
 		sort.Slice(recs, func(i, j int) bool {
 			return recs[i].Time >= recs[j].Time
 		})
 
-		for i := range recs {
-			r := recs[i]
-			allRecs = append(allRecs, recs[i])
-			ts := time.Unix(int64(r.Time), 0).UTC()
-			asset := f.assets[r.Asset]
+		allRecs = append(allRecs, recs...)
 
-			switch r.Type {
-			case "deposit", "withdrawal":
-				count++
+		if page != nil {
+			if err := page(recs, allRecs); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// collectLedgerRecs collects the deposits, withdrawals and adjustments among recs, as well as "spend" and "receive"
+// entries by their reference id, so they can be submitted once all entries are known.
+func (f *Fetcher) collectLedgerRecs(ctx context.Context, recs []g.LedgerRec, spendsAndReceives map[string][]g.LedgerRec) error {
+	if err := f.learnAssets(recs); err != nil {
+		return err
+	}
 
-				transfer := &proto.Transfer{
-					TxID:          r.ID,
-					Ts:            timestamppb.New(ts),
-					Account:       f.label,
-					Fee:           g.StrToDecimal(r.Fee).Abs().String(),
-					Plugin:        g.Plugin.ID,
-					PluginVersion: g.Plugin.Version,
-					Created:       timestamppb.New(time.Now().UTC()),
-					Asset:         normalizeCurrency(r.Asset),
-					AssetDecimals: int32(asset.Decimals),
-					Amount:        g.StrToDecimal(r.Amount).Abs().String(),
-					FeeCurrency:   normalizeCurrency(r.Asset),
-					FeeDecimals:   int32(asset.Decimals),
-				}
-
-				if r.Type == "deposit" {
-					transfer.Action = proto.TransferAction_DEPOSIT
-					transfer.Destination = f.label
-				}
-
-				if r.Type == "withdrawal" {
-					transfer.Action = proto.TransferAction_WITHDRAWAL
-					transfer.Source = f.label
-				}
-
-				grpc_client.GrpcClient.SubmitTransfer(context.Background(), transfer)
-			case "spend", "receive":
-				if _, ok := allSpendsAndReceives[recs[i].RefID]; !ok {
-					allSpendsAndReceives[recs[i].RefID] = []g.LedgerRec{}
-				}
-
-				allSpendsAndReceives[recs[i].RefID] = append(allSpendsAndReceives[recs[i].RefID], recs[i])
+	f.loadTransferStatus(ctx, recs)
+
+	for _, r := range recs {
+		switch r.Type {
+		case "deposit", "withdrawal":
+			f.deposits = append(f.deposits, r)
+		case "spend", "receive":
+			spendsAndReceives[r.RefID] = append(spendsAndReceives[r.RefID], r)
+		default:
+			if isLedgerAdjustment(r) {
+				f.adjustments = append(f.adjustments, r)
 			}
 		}
+	}
 
-		grpc_client.GrpcClient.ShowJobProgress(context.Background(), &proto.JobProgress{
-			ID:       jobId,
-			Label:    fmt.Sprintf("Fetched %d transfers for account \"%s\"", count, f.label),
-			Progress: "-1",
-		})
+	return nil
+}
+
+// submitLedgerTransfers submits the collected deposits and withdrawals, except for reversed ones. Reversals are
+// detected among all collected entries first and are taken out of the adjustments. It returns the number of transfers.
+func (f *Fetcher) submitLedgerTransfers(ctx context.Context) (int, error) {
+	count := 0
+
+	candidates := append(append([]g.LedgerRec{}, f.deposits...), f.adjustments...)
+	f.findReversals(candidates)
+
+	adjustments := []g.LedgerRec{}
+	for _, r := range f.adjustments {
+		if !f.isKnownReversal(r) {
+			adjustments = append(adjustments, r)
+		}
 	}
+	f.adjustments = adjustments
 
-	// Compose trades out of "spend" and "receive" trades. These are credit card purchases.
-	for refId, recs := range allSpendsAndReceives {
+	for _, r := range f.deposits {
+		if f.isKnownReversal(r) || f.skipReversed(r) {
+			continue
+		}
+
+		count++
+
+		transfer := f.ledgerTransfer(r)
+
+		if r.Type == "deposit" {
+			transfer.Action = proto.TransferAction_DEPOSIT
+			transfer.Destination = f.label
+		}
+
+		if r.Type == "withdrawal" {
+			transfer.Action = proto.TransferAction_WITHDRAWAL
+			transfer.Source = f.label
+		}
+
+		f.enrichTransfer(transfer, r)
+
+		if err := f.submitTransfer(ctx, transfer, f.transferStatus[r.RefID].TxID); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// submitCardPurchases composes trades out of "spend" and "receive" ledger entries that share a reference id.
+// These are credit card purchases.
+func (f *Fetcher) submitCardPurchases(ctx context.Context, spendsAndReceives map[string][]g.LedgerRec) error {
+	for refId, recs := range spendsAndReceives {
 		spend := findRecByType("spend", recs)
 		receive := findRecByType("receive", recs)
 
@@ -430,8 +512,8 @@ outer:
 			continue
 		}
 
-		baseAssetNormalized := normalizeCurrency(receive.Asset)
-		quoteAssetNormalized := normalizeCurrency(spend.Asset)
+		baseAssetNormalized := normalizeCurrency(receive.Asset, f.currencies)
+		quoteAssetNormalized := normalizeCurrency(spend.Asset, f.currencies)
 		ts := time.Unix(int64(spend.Time), 0).UTC().Add(time.Millisecond)
 
 		baseAsset := f.assets[receive.Asset]
@@ -440,11 +522,7 @@ outer:
 		spendAmount := g.StrToDecimal(spend.Amount).Abs()
 		receiveAmount := g.StrToDecimal(receive.Amount).Abs()
 
-		props := &proto.TradeProps{
-			IsMarginTrade: false,
-			IsPhysical:    true,
-			IsDerivative:  false,
-		}
+		props := tradeProps(PairInfo{}, baseAsset, quoteAsset, false, f.classes)
 
 		trade := &proto.Trade{
 			TxID:             refId,
@@ -473,11 +551,12 @@ outer:
 			Comment:          "Credit card purchase",
 		}
 
-		grpc_client.GrpcClient.SubmitTrade(context.Background(), trade)
+		if err := f.sink.SubmitTrade(ctx, trade); err != nil {
+			return err
+		}
 	}
 
-	grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_INFO, Message: fmt.Sprintf("[%s] Fetched %d new transfers from %s.", g.Plugin.Label, count, f.label)})
-	return allRecs, nil
+	return nil
 }
 
 func findRecByType(t string, recs []g.LedgerRec) *g.LedgerRec {
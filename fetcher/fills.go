@@ -0,0 +1,202 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/f-taxes/kraken_import/conf"
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/shopspring/decimal"
+)
+
+// aggregateFills reports if partial fills of an order are combined into a single trade.
+func aggregateFills() bool {
+	return conf.App != nil && conf.App.Bool("trades.aggregateFills", false)
+}
+
+// aggregateWindow is the length of the windows fills of an order are combined in.
+func aggregateWindow() time.Duration {
+	return time.Duration(conf.App.Int("trades.aggregateWindow", 3600)) * time.Second
+}
+
+// orderDetails reports if trades are enriched with the details of their order, which requires loading all orders.
+func orderDetails() bool {
+	return conf.App != nil && conf.App.Bool("trades.orderDetails", false)
+}
+
+// emitTrade submits a trade or holds it back until flushFills if fills are aggregated.
+func (f *Fetcher) emitTrade(ctx context.Context, trade *proto.Trade) error {
+	if !aggregateFills() || trade.OrderID == "" {
+		return f.sink.SubmitTrade(ctx, trade)
+	}
+
+	if f.fills == nil {
+		f.fills = map[string][]*proto.Trade{}
+	}
+
+	f.fills[trade.OrderID] = append(f.fills[trade.OrderID], trade)
+	return nil
+}
+
+// heldFills returns the number of fills held back until flushFills.
+func (f *Fetcher) heldFills() int {
+	n := 0
+	for _, fills := range f.fills {
+		n += len(fills)
+	}
+	return n
+}
+
+// flushFills combines the held back fills of each order and submits them. It returns the number of submitted trades.
+func (f *Fetcher) flushFills(ctx context.Context) (int, error) {
+	orders := make([]string, 0, len(f.fills))
+	for id := range f.fills {
+		orders = append(orders, id)
+	}
+	sort.Strings(orders)
+
+	count := 0
+	window := aggregateWindow()
+
+	for _, id := range orders {
+		for _, trade := range mergeFills(f.fills[id], window) {
+			if err := f.sink.SubmitTrade(ctx, trade); err != nil {
+				return count, err
+			}
+			count++
+		}
+		delete(f.fills, id)
+	}
+
+	return count, nil
+}
+
+// fillGroupKey separates fills that can't be combined: different sides, roles, fee currencies or margin.
+func fillGroupKey(t *proto.Trade) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%v", t.Action, t.OrderType, t.FeeCurrency, t.QuoteFeeCurrency, t.Props.GetIsMarginTrade())
+}
+
+// fillWindowStart returns the start of the window t falls into. Windows are aligned to the Unix epoch, so they
+// don't depend on the span that is fetched.
+func fillWindowStart(t time.Time, window time.Duration) time.Time {
+	w := int64(window / time.Second)
+	if w <= 0 || t.Unix() <= 0 {
+		return t
+	}
+
+	return time.Unix(t.Unix()-t.Unix()%w, 0).UTC()
+}
+
+// mergeFills combines the fills of one order that fall into the same window (see fillWindowStart) and can be
+// combined (see fillGroupKey). The combined trade has the time of its first fill and an id derived from the order,
+// the window and the group, so fetching more fills of the same window replaces it instead of adding another trade.
+func mergeFills(fills []*proto.Trade, window time.Duration) []*proto.Trade {
+	sort.Slice(fills, func(i, j int) bool {
+		return fills[i].Ts.AsTime().Before(fills[j].Ts.AsTime())
+	})
+
+	groups := map[string][]*proto.Trade{}
+	ids := []string{}
+
+	for _, fill := range fills {
+		key := fillGroupKey(fill)
+		id := fmt.Sprintf("%s-%d-%08x", fill.OrderID, fillWindowStart(fill.Ts.AsTime(), window).Unix(), crc32.ChecksumIEEE([]byte(key)))
+
+		if _, ok := groups[id]; !ok {
+			ids = append(ids, id)
+		}
+		groups[id] = append(groups[id], fill)
+	}
+
+	merged := []*proto.Trade{}
+	for _, id := range ids {
+		merged = append(merged, mergeGroup(id, groups[id]))
+	}
+
+	return merged
+}
+
+// mergeGroup combines fills into a trade with the given id.
+func mergeGroup(id string, group []*proto.Trade) *proto.Trade {
+	first := group[0]
+	amount, value, fee, quoteFee := decimal.Zero, decimal.Zero, decimal.Zero, decimal.Zero
+	ids := make([]string, 0, len(group))
+
+	for _, t := range group {
+		amount = amount.Add(g.StrToDecimal(t.Amount))
+		value = value.Add(g.StrToDecimal(t.Value))
+		fee = fee.Add(g.StrToDecimal(t.Fee))
+		quoteFee = quoteFee.Add(g.StrToDecimal(t.QuoteFee))
+		ids = append(ids, t.TxID)
+	}
+
+	price := first.Price
+	if len(group) > 1 && !amount.IsZero() {
+		price = value.Div(amount).String()
+	}
+
+	comment := fmt.Sprintf("Combined %d fills: %s", len(group), strings.Join(ids, ", "))
+	if len(group) == 1 {
+		comment = "Fill " + first.TxID
+	}
+	if first.Comment != "" {
+		comment = first.Comment + ". " + comment
+	}
+
+	trade := &proto.Trade{
+		TxID:             id,
+		Ts:               first.Ts,
+		Account:          first.Account,
+		Ticker:           first.Ticker,
+		Quote:            first.Quote,
+		Asset:            first.Asset,
+		Price:            price,
+		Amount:           amount.String(),
+		Value:            value.String(),
+		Action:           first.Action,
+		OrderType:        first.OrderType,
+		OrderID:          first.OrderID,
+		Fee:              fee.String(),
+		FeeCurrency:      first.FeeCurrency,
+		QuoteFee:         quoteFee.String(),
+		QuoteFeeCurrency: first.QuoteFeeCurrency,
+		AssetDecimals:    first.AssetDecimals,
+		QuoteDecimals:    first.QuoteDecimals,
+		FeeDecimals:      first.FeeDecimals,
+		QuoteFeeDecimals: first.QuoteFeeDecimals,
+		Props:            first.Props,
+		Plugin:           first.Plugin,
+		PluginVersion:    first.PluginVersion,
+		Created:          first.Created,
+		Comment:          comment,
+	}
+
+	return trade
+}
+
+// orderComment describes the order of a trade, e.g. "buy 1.25 XBTEUR @ limit 27500.0 (userref 42, flags post,fciq)".
+func (f *Fetcher) orderComment(orderID string) string {
+	o, ok := f.orders[orderID]
+	if !ok || o.Description.Order == "" {
+		return ""
+	}
+
+	details := []string{}
+	if o.UserRef != 0 {
+		details = append(details, fmt.Sprintf("userref %d", o.UserRef))
+	}
+	if o.OrderFlags != "" {
+		details = append(details, "flags "+o.OrderFlags)
+	}
+
+	if len(details) == 0 {
+		return "Order: " + o.Description.Order
+	}
+
+	return fmt.Sprintf("Order: %s (%s)", o.Description.Order, strings.Join(details, ", "))
+}
@@ -0,0 +1,134 @@
+package fetcher
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/f-taxes/kraken_import/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var fillsStart = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+func testFill(txID string, offset time.Duration, role proto.OrderType, feeCurrency, amount, value, fee string) *proto.Trade {
+	return &proto.Trade{
+		TxID:             txID,
+		Ts:               timestamppb.New(fillsStart.Add(offset)),
+		OrderID:          "OABCDE-FGHIJ-KLMNOP",
+		Action:           proto.TxAction_BUY,
+		OrderType:        role,
+		Asset:            "BTC",
+		Quote:            "EUR",
+		Price:            "60000.000000",
+		Amount:           amount,
+		Value:            value,
+		Fee:              fee,
+		FeeCurrency:      feeCurrency,
+		QuoteFeeCurrency: "EUR",
+		Props:            &proto.TradeProps{},
+	}
+}
+
+func TestMergeFills(t *testing.T) {
+	type trade struct {
+		fills  int
+		amount string
+		value  string
+		fee    string
+		price  string
+	}
+
+	maker, taker := proto.OrderType_MAKER, proto.OrderType_TAKER
+
+	tests := []struct {
+		name  string
+		fills []*proto.Trade
+		want  []trade
+	}{
+		{
+			name: "single fill keeps its price",
+			fills: []*proto.Trade{
+				testFill("T1", time.Minute, maker, "BTC", "0.5", "30000", "0.001"),
+			},
+			want: []trade{{1, "0.5", "30000", "0.001", "60000.000000"}},
+		},
+		{
+			name: "fills of a window are combined",
+			fills: []*proto.Trade{
+				testFill("T2", 20*time.Minute, maker, "BTC", "0.25", "15500", "0"),
+				testFill("T1", time.Minute, maker, "BTC", "0.75", "45000", "0.002"),
+			},
+			want: []trade{{2, "1", "60500", "0.002", "60500"}},
+		},
+		{
+			name: "windows are split",
+			fills: []*proto.Trade{
+				testFill("T1", 59*time.Minute, maker, "BTC", "1", "60000", "0"),
+				testFill("T2", 61*time.Minute, maker, "BTC", "1", "61000", "0"),
+			},
+			want: []trade{{1, "1", "60000", "0", "60000.000000"}, {1, "1", "61000", "0", "60000.000000"}},
+		},
+		{
+			name: "makers and takers are kept apart",
+			fills: []*proto.Trade{
+				testFill("T1", 0, taker, "BTC", "1", "60000", "0"),
+				testFill("T2", time.Second, maker, "BTC", "2", "120000", "0"),
+				testFill("T3", 2*time.Second, maker, "BTC", "1", "60000", "0"),
+			},
+			want: []trade{{1, "1", "60000", "0", "60000.000000"}, {2, "3", "180000", "0", "60000"}},
+		},
+		{
+			name: "fee currencies are kept apart",
+			fills: []*proto.Trade{
+				testFill("T1", 0, maker, "BTC", "1", "60000", "0.001"),
+				testFill("T2", time.Minute, maker, "KFEE", "1", "60000", "120"),
+			},
+			want: []trade{{1, "1", "60000", "0.001", "60000.000000"}, {1, "1", "60000", "120", "60000.000000"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeFills(tt.fills, time.Hour)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d trades, want %d: %v", len(got), len(tt.want), got)
+			}
+
+			for i, w := range tt.want {
+				g := got[i]
+				if g.Amount != w.amount || g.Value != w.value || g.Fee != w.fee || g.Price != w.price {
+					t.Errorf("trade %d = %s for %s, fee %s at %s, want %s for %s, fee %s at %s", i, g.Amount, g.Value, g.Fee, g.Price, w.amount, w.value, w.fee, w.price)
+				}
+				if (w.fills == 1) != strings.HasPrefix(g.Comment, "Fill ") {
+					t.Errorf("trade %d of %d fills has comment %q", i, w.fills, g.Comment)
+				}
+			}
+		})
+	}
+}
+
+// A fetch that ends within a window combines the fills it knows of. The next fetch loads the whole window again,
+// its trade has to replace the first one.
+func TestMergeFillsAcrossFetches(t *testing.T) {
+	a := testFill("T1", time.Minute, proto.OrderType_MAKER, "BTC", "1", "60000", "0")
+	b := testFill("T2", 2*time.Minute, proto.OrderType_MAKER, "BTC", "1", "60000", "0")
+	c := testFill("T3", 40*time.Minute, proto.OrderType_MAKER, "BTC", "1", "60000", "0")
+
+	first := mergeFills([]*proto.Trade{a, b}, time.Hour)
+	second := mergeFills([]*proto.Trade{a, b, c}, time.Hour)
+
+	if len(first) != 1 || len(second) != 1 {
+		t.Fatalf("got %d and %d trades, want one each", len(first), len(second))
+	}
+	if first[0].TxID != second[0].TxID || first[0].TxID == a.TxID {
+		t.Errorf("ids %s and %s, want the same id derived from the order", first[0].TxID, second[0].TxID)
+	}
+	if second[0].Amount != "3" {
+		t.Errorf("second trade amounts to %s, want 3", second[0].Amount)
+	}
+
+	if got := fillWindowStart(c.Ts.AsTime(), time.Hour); !got.Equal(fillsStart) {
+		t.Errorf("window of %s starts at %s, want %s", c.Ts.AsTime(), got, fillsStart)
+	}
+}
@@ -0,0 +1,387 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/f-taxes/kraken_import/accounts"
+	"github.com/f-taxes/kraken_import/conf"
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/grpc_client"
+	"github.com/f-taxes/kraken_import/outbox"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/f-taxes/kraken_import/store"
+	"github.com/shopspring/decimal"
+	"google.golang.org/protobuf/encoding/protojson"
+	pb "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const transfersPrefix = "transfers:"
+
+// chainTxIDPrefix keeps the blockchain txids of transfers queued in the outbox, per batch, until they are delivered.
+const chainTxIDPrefix = "chaintxids:"
+
+// depositLead is how much earlier than its withdrawal a deposit may be timestamped, as the ledgers of two
+// accounts aren't necessarily booked in order.
+const depositLead = 10 * time.Minute
+
+// matchTransfers reports if deposits and withdrawals are matched against the transfers of the other accounts.
+func matchTransfers() bool {
+	return conf.App != nil && conf.App.Bool("transfers.matchAccounts", true)
+}
+
+// matchWindow is how long after a withdrawal the matching deposit may arrive.
+func matchWindow() time.Duration {
+	return time.Duration(conf.App.Int("transfers.matchWindow", 24)) * time.Hour
+}
+
+// TransferIndex remembers the deposits and withdrawals of all accounts that have been delivered to the core, so a
+// transfer between two configured accounts is matched no matter which of them is fetched first. Fetches only read
+// from the index. Transfers are added once they have been delivered, see Delivered.
+type TransferIndex struct {
+	mu sync.Mutex
+	db *badger.DB
+}
+
+// Transfers is the index used by all fetchers. Matching is disabled while it's nil.
+var Transfers *TransferIndex
+
+// InitTransfers opens the transfer index kept in db.
+func InitTransfers(db *badger.DB) {
+	Transfers = &TransferIndex{db: db}
+}
+
+type indexedTransfer struct {
+	AccountID   string `json:"accountId"`
+	Account     string `json:"account"`
+	ChainTxID   string `json:"chainTxId"`
+	MatchedWith string `json:"matchedWith"` // Index key of the other end, once the transfer has been matched.
+	Record      []byte `json:"record"`      // The transfer as it was delivered, as protojson.
+	key         string
+	transfer    *proto.Transfer
+}
+
+func transferKey(t *proto.Transfer) string {
+	return fmt.Sprintf("%s%s:%012d:%s", transfersPrefix, t.Asset, t.Ts.AsTime().Unix(), t.TxID)
+}
+
+func chainTxIDKey(batchID, txID string) string {
+	return chainTxIDPrefix + batchID + ":" + txID
+}
+
+// noteChainTxID keeps the blockchain txid of a transfer queued in an outbox batch until the transfer is delivered
+// and indexed.
+func (x *TransferIndex) noteChainTxID(batchID, txID, chainTxID string) error {
+	if chainTxID == "" {
+		return nil
+	}
+
+	return x.db.Update(func(txn *badger.Txn) error {
+		return store.PutJSON(txn, chainTxIDKey(batchID, txID), chainTxID)
+	})
+}
+
+// Delivered indexes a transfer once it has been delivered to the core and matches it with the other end if that is
+// still unmatched. Ends whose source or destination changed by the match are queued in ob again, each under its own
+// account.
+func (x *TransferIndex) Delivered(ob *outbox.Outbox, b outbox.Batch, rec *proto.Record) {
+	if rec.Transfer == nil || !matchTransfers() {
+		return
+	}
+
+	changed, err := x.put(b.AccountID, b.ID, rec.Transfer, accountLabels())
+	if err != nil {
+		grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_WARN, Message: fmt.Sprintf("[%s] Failed to remember transfer %s for matching: %s", g.Plugin.Label, rec.Transfer.TxID, err)})
+		return
+	}
+
+	for _, e := range changed {
+		e.transfer.Updated = timestamppb.New(time.Now().UTC())
+
+		if err := ob.Enqueue(e.AccountID, &proto.Record{Transfer: e.transfer}); err != nil {
+			grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_WARN, Message: fmt.Sprintf("[%s] Failed to queue matched transfer %s: %s", g.Plugin.Label, e.transfer.TxID, err)})
+		}
+	}
+}
+
+// put adds a transfer of an account, delivered with the outbox batch batchID, to the index and matches it. Transfers
+// that are delivered again replace the earlier version but keep their match. The ends that differ from what has been
+// delivered are returned.
+func (x *TransferIndex) put(accountID, batchID string, t *proto.Transfer, labels map[string]bool) ([]indexedTransfer, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	changed := []indexedTransfer{}
+
+	err := x.db.Update(func(txn *badger.Txn) error {
+		e := indexedTransfer{}
+		if err := store.GetJSON(txn, transferKey(t), &e); err != nil && !store.IsNotFound(err) {
+			return err
+		}
+
+		chainTxID := ""
+		if err := store.GetJSON(txn, chainTxIDKey(batchID, t.TxID), &chainTxID); err == nil {
+			if err := txn.Delete([]byte(chainTxIDKey(batchID, t.TxID))); err != nil {
+				return err
+			}
+		} else if !store.IsNotFound(err) {
+			return err
+		}
+
+		e.AccountID, e.Account, e.key = accountID, t.Account, transferKey(t)
+		e.transfer = pb.Clone(t).(*proto.Transfer)
+		if chainTxID != "" {
+			e.ChainTxID = chainTxID
+		}
+
+		if e.MatchedWith == "" {
+			other, err := x.match(txn, t, e.ChainTxID, labels)
+			if err != nil {
+				return err
+			}
+
+			if other != nil {
+				delivered := pb.Clone(other.transfer)
+				e.MatchedWith, other.MatchedWith = other.key, e.key
+				linkTransfers(e.transfer, other.transfer)
+
+				if err := putIndexed(txn, other); err != nil {
+					return err
+				}
+
+				if other.AccountID != "" && !pb.Equal(delivered, other.transfer) {
+					changed = append(changed, *other)
+				}
+				if !pb.Equal(t, e.transfer) {
+					changed = append(changed, e)
+				}
+			}
+		}
+
+		return putIndexed(txn, &e)
+	})
+
+	return changed, err
+}
+
+func putIndexed(txn *badger.Txn, e *indexedTransfer) error {
+	data, err := protojson.Marshal(e.transfer)
+	if err != nil {
+		return err
+	}
+
+	e.Record = data
+	return store.PutJSON(txn, e.key, e)
+}
+
+func getIndexed(txn *badger.Txn, key string) (*indexedTransfer, error) {
+	e := &indexedTransfer{key: key, transfer: &proto.Transfer{}}
+	if err := store.GetJSON(txn, key, e); err != nil {
+		return nil, err
+	}
+
+	return e, protojson.Unmarshal(e.Record, e.transfer)
+}
+
+// between returns the transfers of an asset within from and to.
+func between(txn *badger.Txn, asset string, from, to time.Time) ([]indexedTransfer, error) {
+	prefix := fmt.Sprintf("%s%s:", transfersPrefix, asset)
+	last := fmt.Sprintf("%s%012d", prefix, to.Unix())
+	found := []indexedTransfer{}
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = []byte(prefix)
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek([]byte(fmt.Sprintf("%s%012d", prefix, max(from.Unix(), 0)))); it.Valid(); it.Next() {
+		key := string(it.Item().Key())
+		if key[:len(last)] > last {
+			break
+		}
+
+		e, err := getIndexed(txn, key)
+		if err != nil {
+			return nil, err
+		}
+
+		found = append(found, *e)
+	}
+
+	return found, nil
+}
+
+// Match finds the other end of t among the transfers of the accounts in labels: a withdrawal and a deposit of the same
+// asset whose blockchain txids agree or, if one is unknown, whose amounts agree and that are at most matchWindow apart.
+// The closest one in time wins. Transfers that have been matched with another end already are left out.
+func (x *TransferIndex) Match(t *proto.Transfer, chainTxID string, labels map[string]bool) (*indexedTransfer, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var other *indexedTransfer
+
+	err := x.db.View(func(txn *badger.Txn) error {
+		var err error
+		other, err = x.match(txn, t, chainTxID, labels)
+		return err
+	})
+
+	return other, err
+}
+
+func (x *TransferIndex) match(txn *badger.Txn, t *proto.Transfer, chainTxID string, labels map[string]bool) (*indexedTransfer, error) {
+	key := transferKey(t)
+
+	// A transfer that has been matched before keeps its other end.
+	own := indexedTransfer{}
+	if err := store.GetJSON(txn, key, &own); err != nil && !store.IsNotFound(err) {
+		return nil, err
+	}
+	if own.MatchedWith != "" {
+		other, err := getIndexed(txn, own.MatchedWith)
+		if store.IsNotFound(err) {
+			return nil, nil
+		}
+		return other, err
+	}
+
+	ts := t.Ts.AsTime()
+	from, to := ts.Add(-depositLead), ts.Add(matchWindow())
+	if t.Action == proto.TransferAction_DEPOSIT {
+		from, to = ts.Add(-matchWindow()), ts.Add(depositLead)
+	}
+
+	candidates, err := between(txn, t.Asset, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *indexedTransfer
+	var bestDist time.Duration
+
+	for i := range candidates {
+		c := &candidates[i]
+
+		if c.Account == t.Account || c.transfer.Action == t.Action || (labels != nil && !labels[c.Account]) {
+			continue
+		}
+
+		if c.MatchedWith != "" && c.MatchedWith != key {
+			continue
+		}
+
+		if chainTxID != "" && c.ChainTxID != "" {
+			if chainTxID == c.ChainTxID {
+				return c, nil
+			}
+			continue
+		}
+
+		withdrawal, deposit := t, c.transfer
+		if t.Action == proto.TransferAction_DEPOSIT {
+			withdrawal, deposit = c.transfer, t
+		}
+
+		if !amountsMatch(withdrawal, deposit) {
+			continue
+		}
+
+		dist := c.transfer.Ts.AsTime().Sub(ts).Abs()
+		if best == nil || dist < bestDist {
+			best, bestDist = c, dist
+		}
+	}
+
+	return best, nil
+}
+
+// amountsMatch reports if the deposit received what the withdrawal sent, with or without the withdrawal fee
+// taken off.
+func amountsMatch(withdrawal, deposit *proto.Transfer) bool {
+	sent := g.StrToDecimal(withdrawal.Amount)
+	received := g.StrToDecimal(deposit.Amount)
+	receivedGross := received.Add(g.StrToDecimal(deposit.Fee))
+
+	for _, s := range []decimal.Decimal{sent, sent.Sub(g.StrToDecimal(withdrawal.Fee))} {
+		if s.Equal(received) || s.Equal(receivedGross) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// accountLabels returns the labels of all configured accounts or nil if they are unknown.
+func accountLabels() map[string]bool {
+	if accounts.Repo == nil {
+		return nil
+	}
+
+	list, err := accounts.Repo.List()
+	if err != nil {
+		return nil
+	}
+
+	labels := map[string]bool{}
+	for _, acc := range list {
+		labels[acc.Label] = true
+	}
+
+	return labels
+}
+
+// submitTransfer submits a deposit or withdrawal. If it moved funds between two configured accounts and the other end
+// has been delivered already, it's filled with the other account. The other end is updated once this one is delivered.
+// The blockchain txid is only kept for transfers queued in the outbox, previewed transfers are matched by their amount.
+func (f *Fetcher) submitTransfer(ctx context.Context, transfer *proto.Transfer, chainTxID string) error {
+	if Transfers == nil || !matchTransfers() {
+		return f.sink.SubmitTransfer(ctx, transfer)
+	}
+
+	if b, ok := f.sink.(*outbox.Batch); ok {
+		if err := Transfers.noteChainTxID(b.ID, transfer.TxID, chainTxID); err != nil {
+			grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_WARN, Message: fmt.Sprintf("[%s] Failed to remember the blockchain txid of transfer %s: %s", g.Plugin.Label, transfer.TxID, err)})
+		}
+	}
+
+	other, err := Transfers.Match(transfer, chainTxID, accountLabels())
+	if err != nil {
+		grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_WARN, Message: fmt.Sprintf("[%s] Failed to match transfer %s against the other accounts: %s", g.Plugin.Label, transfer.TxID, err)})
+	}
+
+	if other != nil {
+		linkTransfers(transfer, other.transfer)
+	}
+
+	return f.sink.SubmitTransfer(ctx, transfer)
+}
+
+// linkTransfers fills the withdrawal's destination and the deposit's source with the other account.
+func linkTransfers(a, b *proto.Transfer) {
+	withdrawal, deposit := a, b
+	if a.Action == proto.TransferAction_DEPOSIT {
+		withdrawal, deposit = b, a
+	}
+
+	withdrawal.Destination = deposit.Account
+	deposit.Source = withdrawal.Account
+	withdrawal.Comment = joinComment(withdrawal.Comment, fmt.Sprintf("Internal transfer to %s", deposit.Account))
+	deposit.Comment = joinComment(deposit.Comment, fmt.Sprintf("Internal transfer from %s", withdrawal.Account))
+}
+
+func joinComment(comment, note string) string {
+	if comment == "" {
+		return note
+	}
+	if strings.Contains(comment, note) {
+		return comment
+	}
+	return comment + "; " + note
+}
@@ -0,0 +1,170 @@
+package fetcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/f-taxes/kraken_import/outbox"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/f-taxes/kraken_import/store"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func testTransfer(txID, account string, action proto.TransferAction, ts time.Time, amount, fee string) *proto.Transfer {
+	return &proto.Transfer{TxID: txID, Ts: timestamppb.New(ts), Account: account, Action: action, Asset: "ETH", Amount: amount, Fee: fee}
+}
+
+func TestTransferMatching(t *testing.T) {
+	f, fetched := setupFetcher(t, "Kraken B")
+
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	InitTransfers(db)
+	t.Cleanup(func() { Transfers = nil })
+
+	delivered := &recordingSink{}
+	ob := outbox.New(db, delivered)
+	ob.OnRecordDelivered(func(b outbox.Batch, rec *proto.Record) {
+		Transfers.Delivered(ob, b, rec)
+	})
+
+	deliver := func(accountID string, tr *proto.Transfer) {
+		t.Helper()
+		if err := ob.Enqueue(accountID, &proto.Record{Transfer: tr}); err != nil {
+			t.Fatal(err)
+		}
+		// Twice, the second flush delivers what the first one queued again.
+		for i := 0; i < 2; i++ {
+			if err := ob.Flush(context.Background(), ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	indexed := func() int {
+		n := 0
+		db.View(func(txn *badger.Txn) error {
+			n = len(store.Keys(txn, transfersPrefix))
+			return nil
+		})
+		return n
+	}
+
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	deliver("a", testTransfer("W1", "Kraken A", proto.TransferAction_WITHDRAWAL, t0, "1", "0.01"))
+
+	// Fetching only reads from the index.
+	d1 := testTransfer("D1", "Kraken B", proto.TransferAction_DEPOSIT, t0.Add(time.Hour), "0.99", "0")
+	if err := f.submitTransfer(context.Background(), d1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if d1.Source != "Kraken A" {
+		t.Errorf("D1 source = %q, want Kraken A", d1.Source)
+	}
+	if n := indexed(); n != 1 {
+		t.Errorf("index holds %d transfers after fetching, want 1", n)
+	}
+
+	// The withdrawal is delivered again with its destination once the deposit has been delivered.
+	delivered.transfers = nil
+	deliver("b", d1)
+
+	if len(delivered.transfers) != 2 || delivered.transfers[1].TxID != "W1" || delivered.transfers[1].Destination != "Kraken B" {
+		t.Fatalf("delivered %v, want D1 and W1 with destination Kraken B", delivered.transfers)
+	}
+
+	// The withdrawal is matched already, another deposit of the same amount doesn't take it.
+	d2 := testTransfer("D2", "Kraken B", proto.TransferAction_DEPOSIT, t0.Add(2*time.Hour), "0.99", "0")
+	if err := f.submitTransfer(context.Background(), d2, ""); err != nil {
+		t.Fatal(err)
+	}
+	if d2.Source != "" {
+		t.Errorf("D2 source = %q, want it unmatched", d2.Source)
+	}
+
+	// Fetching the matched ends again fills them the same way.
+	w1 := testTransfer("W1", "Kraken A", proto.TransferAction_WITHDRAWAL, t0, "1", "0.01")
+	f.label = "Kraken A"
+	if err := f.submitTransfer(context.Background(), w1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if w1.Destination != "Kraken B" {
+		t.Errorf("W1 destination = %q on refetch, want Kraken B", w1.Destination)
+	}
+
+	if len(fetched.transfers) != 3 {
+		t.Errorf("fetch submitted %d transfers, want 3", len(fetched.transfers))
+	}
+}
+
+func TestTransferMatchingByChainTxID(t *testing.T) {
+	f, _ := setupFetcher(t, "Kraken A")
+
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	InitTransfers(db)
+	t.Cleanup(func() { Transfers = nil })
+
+	ob := outbox.New(db, &recordingSink{})
+	ob.OnRecordDelivered(func(b outbox.Batch, rec *proto.Record) {
+		Transfers.Delivered(ob, b, rec)
+	})
+
+	noted := func() int {
+		n := 0
+		db.View(func(txn *badger.Txn) error {
+			n = len(store.Keys(txn, chainTxIDPrefix))
+			return nil
+		})
+		return n
+	}
+
+	// A fetch that doesn't go through the outbox doesn't keep the txid.
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := f.submitTransfer(context.Background(), testTransfer("W0", "Kraken A", proto.TransferAction_WITHDRAWAL, t0, "1", "0"), "0xdef"); err != nil {
+		t.Fatal(err)
+	}
+	if n := noted(); n != 0 {
+		t.Errorf("kept %d txids without the outbox, want 0", n)
+	}
+
+	b, err := ob.Begin("a", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.sink = b
+
+	if err := f.submitTransfer(context.Background(), testTransfer("W1", "Kraken A", proto.TransferAction_WITHDRAWAL, t0, "1", "0.01"), "0xabc"); err != nil {
+		t.Fatal(err)
+	}
+	if n := noted(); n != 1 {
+		t.Errorf("kept %d txids while queued, want 1", n)
+	}
+
+	if err := b.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := noted(); n != 0 {
+		t.Errorf("kept %d txids after the delivery, want 0", n)
+	}
+
+	// The amounts differ, only the txid links the deposit to the withdrawal.
+	d1 := testTransfer("D1", "Kraken B", proto.TransferAction_DEPOSIT, t0.Add(time.Hour), "0.5", "0")
+	other, err := Transfers.Match(d1, "0xabc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == nil || other.transfer.TxID != "W1" {
+		t.Errorf("D1 matched %v, want W1 by its txid", other)
+	}
+}
@@ -0,0 +1,234 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/f-taxes/kraken_import/conf"
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/kataras/golog"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Migration describes an asset that kraken replaced by another one, e.g. when a token was redenominated
+// or moved to a new chain. The old asset leaves the account and the new one arrives, usually in separate
+// ledger entries. Both are submitted as a withdrawal of the old asset and a deposit of the new one, as transfers
+// don't dispose of anything. A trade would be booked as a sale of the old asset.
+type Migration struct {
+	From string `mapstructure:"from" json:"from"` // Normalized currency name, e.g. LUNA.
+	To   string `mapstructure:"to" json:"to"`
+	// Window is how far apart (in hours) the two ledger entries may be. Defaults to 48.
+	Window int    `mapstructure:"window" json:"window"`
+	Note   string `mapstructure:"note" json:"note"`
+}
+
+// Migrations returns the migration table from the config.
+func Migrations() []Migration {
+	migrations := []Migration{}
+
+	if conf.App == nil {
+		return migrations
+	}
+
+	if err := conf.App.MapOnExists("ledger.migrations", &migrations); err != nil {
+		golog.Errorf("Failed to read the migration table from the config: %v", err)
+	}
+
+	return migrations
+}
+
+func (m Migration) window() time.Duration {
+	if m.Window <= 0 {
+		return 48 * time.Hour
+	}
+
+	return time.Duration(m.Window) * time.Hour
+}
+
+// isInternalTransfer reports if a ledger entry only moves funds between krakens own wallets
+// (spot, staking, futures), which doesn't change what the user owns.
+func isInternalTransfer(r g.LedgerRec) bool {
+	s := strings.ToLower(r.Subtype)
+	return strings.Contains(s, "staking") || strings.Contains(s, "futures") || strings.Contains(s, "earn")
+}
+
+// isLedgerAdjustment reports if an entry is one of those that submitAdjustments classifies.
+func isLedgerAdjustment(r g.LedgerRec) bool {
+	switch r.Type {
+	case "adjustment", "transfer", "airdrop":
+		return true
+	}
+
+	return r.Subtype == "airdrop"
+}
+
+// isAirdrop reports if an entry credits an airdrop. Transfers of other subtypes aren't airdrops.
+func isAirdrop(r g.LedgerRec) bool {
+	return r.Type == "airdrop" || r.Subtype == "airdrop"
+}
+
+// submitAdjustments classifies "adjustment", "transfer" and "airdrop" ledger entries:
+// pairs that match the migration table become a withdrawal and a deposit (see Migration), everything else becomes
+// a plain deposit or withdrawal. Transfers between krakens own wallets are skipped.
+// f-taxes has no record for income, so airdrops are deposits as well and only named as such in the comment. They
+// have to be classified as income in f-taxes. It returns the number of submitted records.
+func (f *Fetcher) submitAdjustments(ctx context.Context, recs []g.LedgerRec) (int, error) {
+	sorted := append([]g.LedgerRec{}, recs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time < sorted[j].Time })
+
+	used := map[string]bool{}
+	count := 0
+
+	for _, m := range Migrations() {
+		for i := range sorted {
+			out := sorted[i]
+			if used[out.ID] || normalizeCurrency(out.Asset, f.currencies) != m.From || !g.StrToDecimal(out.Amount).IsNegative() {
+				continue
+			}
+
+			in := f.findMigrationTarget(sorted, out, m, used)
+			if in == nil {
+				continue
+			}
+
+			used[out.ID], used[in.ID] = true, true
+
+			if err := f.submitMigration(ctx, out, *in, m); err != nil {
+				return count, err
+			}
+			count += 2
+		}
+	}
+
+	for _, r := range sorted {
+		if used[r.ID] {
+			continue
+		}
+
+		if isInternalTransfer(r) {
+			golog.Debugf("Skipping ledger entry %s, it moves %s %s between kraken wallets (%s).", r.ID, r.Amount, r.Asset, r.Subtype)
+			continue
+		}
+
+		amount := g.StrToDecimal(r.Amount)
+		if amount.IsZero() {
+			continue
+		}
+
+		transfer := f.ledgerTransfer(r)
+
+		switch {
+		case amount.IsPositive() && isAirdrop(r):
+			transfer.Action = proto.TransferAction_DEPOSIT
+			transfer.Source = "Kraken"
+			transfer.Destination = f.label
+			transfer.Comment = fmt.Sprintf("Airdrop of %s", transfer.Asset)
+		case r.Type == "transfer":
+			// Neither income nor a disposal, e.g. fork credits or moves kraken books between wallets we don't know of.
+			transfer.Action = proto.TransferAction_DEPOSIT
+			transfer.Source = "Kraken"
+			transfer.Destination = f.label
+			if amount.IsNegative() {
+				transfer.Action = proto.TransferAction_WITHDRAWAL
+				transfer.Source = f.label
+				transfer.Destination = "Kraken"
+			}
+			transfer.Comment = fmt.Sprintf("Transfer booked by Kraken (%s)", r.Subtype)
+			if r.Subtype == "" {
+				transfer.Comment = "Transfer booked by Kraken"
+			}
+		case amount.IsPositive():
+			transfer.Action = proto.TransferAction_DEPOSIT
+			transfer.Source = "Kraken"
+			transfer.Destination = f.label
+			transfer.Comment = "Balance adjustment by Kraken"
+		default:
+			transfer.Action = proto.TransferAction_WITHDRAWAL
+			transfer.Source = f.label
+			transfer.Destination = "Kraken"
+			transfer.Comment = "Balance adjustment by Kraken"
+		}
+
+		if err := f.sink.SubmitTransfer(ctx, transfer); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// findMigrationTarget finds the entry that credited the new asset of a migration: preferably one with the same
+// reference id, otherwise the closest one in time within the migration's window.
+func (f *Fetcher) findMigrationTarget(recs []g.LedgerRec, out g.LedgerRec, m Migration, used map[string]bool) *g.LedgerRec {
+	var best *g.LedgerRec
+	bestDist := m.window() + 1
+
+	for i := range recs {
+		r := &recs[i]
+		if used[r.ID] || normalizeCurrency(r.Asset, f.currencies) != m.To || !g.StrToDecimal(r.Amount).IsPositive() {
+			continue
+		}
+
+		if r.RefID == out.RefID {
+			return r
+		}
+
+		dist := time.Duration((r.Time - out.Time) * float64(time.Second)).Abs()
+		if dist <= m.window() && dist < bestDist {
+			best = r
+			bestDist = dist
+		}
+	}
+
+	return best
+}
+
+func (f *Fetcher) ledgerTransfer(r g.LedgerRec) *proto.Transfer {
+	asset := f.assets[r.Asset]
+
+	return &proto.Transfer{
+		TxID:          r.ID,
+		Ts:            timestamppb.New(time.Unix(int64(r.Time), 0).UTC()),
+		Account:       f.label,
+		Fee:           g.StrToDecimal(r.Fee).Abs().String(),
+		Plugin:        g.Plugin.ID,
+		PluginVersion: g.Plugin.Version,
+		Created:       timestamppb.New(time.Now().UTC()),
+		Asset:         normalizeCurrency(r.Asset, f.currencies),
+		AssetDecimals: int32(asset.Decimals),
+		Amount:        g.StrToDecimal(r.Amount).Abs().String(),
+		FeeCurrency:   normalizeCurrency(r.Asset, f.currencies),
+		FeeDecimals:   int32(asset.Decimals),
+	}
+}
+
+// submitMigration submits a migration as a withdrawal of the old asset and a deposit of the new one.
+func (f *Fetcher) submitMigration(ctx context.Context, out, in g.LedgerRec, m Migration) error {
+	comment := fmt.Sprintf("Token migration %s → %s", m.From, m.To)
+	if m.Note != "" {
+		comment += ": " + m.Note
+	}
+
+	withdrawal := f.ledgerTransfer(out)
+	withdrawal.Action = proto.TransferAction_WITHDRAWAL
+	withdrawal.Source = f.label
+	withdrawal.Destination = "Kraken"
+	withdrawal.Comment = fmt.Sprintf("%s, replaced by %s", comment, in.ID)
+
+	deposit := f.ledgerTransfer(in)
+	deposit.Action = proto.TransferAction_DEPOSIT
+	deposit.Source = "Kraken"
+	deposit.Destination = f.label
+	deposit.Comment = fmt.Sprintf("%s, replaces %s", comment, out.ID)
+
+	if err := f.sink.SubmitTransfer(ctx, withdrawal); err != nil {
+		return err
+	}
+
+	return f.sink.SubmitTransfer(ctx, deposit)
+}
@@ -0,0 +1,150 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/f-taxes/kraken_import/conf"
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/gookit/config/v2"
+	"github.com/gookit/config/v2/yaml"
+)
+
+// recordingSink keeps everything that is submitted to it.
+type recordingSink struct {
+	trades    []*proto.Trade
+	transfers []*proto.Transfer
+}
+
+func (s *recordingSink) SubmitTrade(ctx context.Context, t *proto.Trade) error {
+	s.trades = append(s.trades, t)
+	return nil
+}
+
+func (s *recordingSink) SubmitTransfer(ctx context.Context, t *proto.Transfer) error {
+	s.transfers = append(s.transfers, t)
+	return nil
+}
+
+const testAssets = `{"error": [], "result": {
+	"XXBT": {"aclass": "currency", "altname": "XBT", "decimals": 10, "display_decimals": 5, "status": "enabled"},
+	"XETH": {"aclass": "currency", "altname": "ETH", "decimals": 10, "display_decimals": 5, "status": "enabled"},
+	"ETH2": {"aclass": "currency", "altname": "ETH2", "decimals": 10, "display_decimals": 5, "status": "enabled"},
+	"MATIC": {"aclass": "currency", "altname": "MATIC", "decimals": 10, "display_decimals": 5, "status": "enabled"},
+	"POL": {"aclass": "currency", "altname": "POL", "decimals": 10, "display_decimals": 5, "status": "enabled"},
+	"FLR": {"aclass": "currency", "altname": "FLR", "decimals": 10, "display_decimals": 5, "status": "enabled"},
+	"ZEUR": {"aclass": "currency", "altname": "EUR", "decimals": 4, "display_decimals": 2, "status": "enabled"}
+}}`
+
+const testConfig = `
+ledger:
+  migrations:
+  - from: MATIC
+    to: POL
+  - from: ETH2
+    to: ETH
+    note: ETH2 unstaking conversion
+`
+
+// setupFetcher prepares a config, a registry that knows testAssets and a fetcher that writes to a recordingSink.
+func setupFetcher(t *testing.T, label string) (*Fetcher, *recordingSink) {
+	t.Helper()
+
+	cfg := config.New("test")
+	cfg.AddDriver(yaml.Driver)
+	if err := cfg.LoadSources(config.Yaml, []byte(testConfig)); err != nil {
+		t.Fatal(err)
+	}
+	conf.App = cfg
+
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := InitRegistry(db, []byte(testAssets)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &recordingSink{}
+	f := &Fetcher{label: label, sink: s}
+	f.assets, f.pairs = Reg.Market()
+
+	return f, s
+}
+
+// readLedger parses entries in the format of ledger.json.
+func readLedger(t *testing.T, data string) []g.LedgerRec {
+	t.Helper()
+
+	recs := []g.LedgerRec{}
+	if err := json.Unmarshal([]byte(data), &recs); err != nil {
+		t.Fatal(err)
+	}
+
+	return recs
+}
+
+func TestSubmitAdjustments(t *testing.T) {
+	f, s := setupFetcher(t, "Kraken")
+
+	recs := readLedger(t, `[
+		{"refid": "MIG1", "time": 1725265000, "type": "adjustment", "aclass": "currency", "asset": "MATIC", "amount": "-120.0000000000", "fee": "0", "ID": "L-MATIC"},
+		{"refid": "MIG2", "time": 1725268600, "type": "adjustment", "aclass": "currency", "asset": "POL", "amount": "120.0000000000", "fee": "0", "ID": "L-POL"},
+		{"refid": "ETH2X", "time": 1700000000, "type": "transfer", "subtype": "", "aclass": "currency", "asset": "ETH2", "amount": "-1.5000000000", "fee": "0", "ID": "L-ETH2"},
+		{"refid": "ETH2X", "time": 1700000000, "type": "transfer", "subtype": "", "aclass": "currency", "asset": "XETH", "amount": "1.5000000000", "fee": "0", "ID": "L-ETH"},
+		{"refid": "AIR1", "time": 1690000000, "type": "airdrop", "aclass": "currency", "asset": "FLR", "amount": "42.0000000000", "fee": "0", "ID": "L-AIR1"},
+		{"refid": "AIR2", "time": 1690000100, "type": "transfer", "subtype": "airdrop", "aclass": "currency", "asset": "FLR", "amount": "8.0000000000", "fee": "0", "ID": "L-AIR2"},
+		{"refid": "FORK", "time": 1690000200, "type": "transfer", "subtype": "", "aclass": "currency", "asset": "FLR", "amount": "3.0000000000", "fee": "0", "ID": "L-FORK"},
+		{"refid": "STAKE", "time": 1690000300, "type": "transfer", "subtype": "spottostaking", "aclass": "currency", "asset": "XETH", "amount": "-1.0000000000", "fee": "0", "ID": "L-STAKE"},
+		{"refid": "ADJ", "time": 1690000400, "type": "adjustment", "aclass": "currency", "asset": "ZEUR", "amount": "-0.0100", "fee": "0", "ID": "L-ADJ"}
+	]`)
+
+	n, err := f.submitAdjustments(context.Background(), recs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 8 {
+		t.Errorf("submitted %d records, want 8", n)
+	}
+
+	// Migrations are transfers, a trade would be booked as a sale of the old asset.
+	if len(s.trades) != 0 {
+		t.Errorf("submitted trades %v, want none", s.trades)
+	}
+
+	want := map[string]struct {
+		action  proto.TransferAction
+		asset   string
+		amount  string
+		comment string
+	}{
+		"L-MATIC": {proto.TransferAction_WITHDRAWAL, "MATIC", "120", "Token migration MATIC → POL, replaced by L-POL"},
+		"L-POL":   {proto.TransferAction_DEPOSIT, "POL", "120", "Token migration MATIC → POL, replaces L-MATIC"},
+		"L-ETH2":  {proto.TransferAction_WITHDRAWAL, "ETH2", "1.5", "Token migration ETH2 → ETH: ETH2 unstaking conversion, replaced by L-ETH"},
+		"L-ETH":   {proto.TransferAction_DEPOSIT, "ETH", "1.5", "Token migration ETH2 → ETH: ETH2 unstaking conversion, replaces L-ETH2"},
+		"L-AIR1":  {proto.TransferAction_DEPOSIT, "FLR", "42", "Airdrop of FLR"},
+		"L-AIR2":  {proto.TransferAction_DEPOSIT, "FLR", "8", "Airdrop of FLR"},
+		"L-FORK":  {proto.TransferAction_DEPOSIT, "FLR", "3", "Transfer booked by Kraken"},
+		"L-ADJ":   {proto.TransferAction_WITHDRAWAL, "EUR", "0.01", "Balance adjustment by Kraken"},
+	}
+
+	if len(s.transfers) != len(want) {
+		t.Errorf("submitted %d transfers, want %d", len(s.transfers), len(want))
+	}
+
+	for _, tr := range s.transfers {
+		w, ok := want[tr.TxID]
+		if !ok {
+			t.Errorf("unexpected transfer %s", tr.TxID)
+			continue
+		}
+		if tr.Action != w.action || tr.Asset != w.asset || tr.Amount != w.amount || tr.Comment != w.comment {
+			t.Errorf("transfer %s = %s %s %s %q, want %s %s %s %q", tr.TxID, tr.Action, tr.Amount, tr.Asset, tr.Comment, w.action, w.amount, w.asset, w.comment)
+		}
+	}
+}
@@ -1,28 +1,87 @@
 package fetcher
 
 import (
+	"strings"
+
+	"github.com/f-taxes/kraken_import/conf"
 	"github.com/shopspring/decimal"
 )
 
+// currenciesAliases maps krakens altnames to the names other exchanges use.
 var currenciesAliases = map[string]string{
-	"ZEUR":     "EUR",
-	"ZUSD":     "USD",
-	"EUR.HOLD": "EUR",
-	"XETH":     "ETH",
-	"XXBT":     "BTC",
-	"XBT":      "BTC",
+	"XBT": "BTC",
 }
 
+// currencySuffixes mark variants of an asset in krakens earn products (staked, opt-in rewards, bonded,
+// flexible, parachain) and assets on hold. They are the same currency for tax purposes.
+var currencySuffixes = []string{".S", ".M", ".B", ".F", ".P", ".HOLD"}
+
 // Convert ambiguous currency strings to something that makes sense.
 // There are, for example ZEUR and EUR.HOLD which both should simply be EUR.
-func normalizeCurrency(v string) string {
+// The asset key is replaced by its altname from the registry (XXRP -> XRP), variant suffixes are removed
+// and the user's overrides (see CurrencyOverrides) are applied last.
+func normalizeCurrency(v string, overrides map[string]string) string {
+	if c, ok := overrides[v]; ok {
+		return c
+	}
+
+	v = trimCurrencySuffix(v)
+
+	if Reg != nil {
+		if altname, ok := Reg.Altname(v); ok {
+			v = altname
+		}
+	}
+
 	if c, ok := currenciesAliases[v]; ok {
+		v = c
+	}
+
+	if c, ok := overrides[v]; ok {
 		return c
 	}
 
 	return v
 }
 
+func trimCurrencySuffix(v string) string {
+	for _, suffix := range currencySuffixes {
+		if base, ok := strings.CutSuffix(v, suffix); ok && base != "" {
+			return base
+		}
+	}
+
+	return v
+}
+
+// CurrencyOverrides returns the user's table of currency names to replace (e.g. XDG -> DOGE).
+func CurrencyOverrides() map[string]string {
+	if conf.App == nil {
+		return nil
+	}
+
+	return conf.App.StringMap("currencies.overrides")
+}
+
+// SetCurrencyOverrides replaces the user's table of currency names and writes it to the config.
+func SetCurrencyOverrides(overrides map[string]string) error {
+	clean := map[string]string{}
+	for from, to := range overrides {
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if from == "" || to == "" {
+			continue
+		}
+		clean[from] = to
+	}
+
+	if err := conf.App.Set("currencies.overrides", clean); err != nil {
+		return err
+	}
+
+	conf.WriteAppConfig()
+	return nil
+}
+
 func roundByCurrency(currency string, val float64) string {
 	switch currency {
 	case "EUR", "USD":
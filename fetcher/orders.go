@@ -0,0 +1,94 @@
+package fetcher
+
+import (
+	"context"
+	"strings"
+
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/krakenapi"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/kataras/golog"
+)
+
+// queryOrdersBatch is the maximum number of orders QueryOrders accepts at once.
+const queryOrdersBatch = 50
+
+// loadOrders loads the orders of trades that lack krakens maker flag, which is missing for older trades,
+// or the orders of all trades if all is set. Failures are only logged, orderType falls back to the order
+// type of the trade then.
+func (f *Fetcher) loadOrders(ctx context.Context, recs []g.TradeRec, all bool) {
+	if f.orders == nil {
+		f.orders = map[string]krakenapi.Order{}
+	}
+
+	missing := []string{}
+	seen := map[string]bool{}
+
+	for _, r := range recs {
+		if (r.Maker != nil && !all) || r.TransactionID == "" || seen[r.TransactionID] {
+			continue
+		}
+
+		if _, ok := f.orders[r.TransactionID]; ok {
+			continue
+		}
+
+		seen[r.TransactionID] = true
+		missing = append(missing, r.TransactionID)
+	}
+
+	for len(missing) > 0 {
+		n := min(len(missing), queryOrdersBatch)
+		batch := missing[:n]
+		missing = missing[n:]
+
+		resp, err := f.restClient.QueryOrders(ctx, batch)
+		if err != nil {
+			golog.Warnf("Failed to load %d orders of account %s to tell maker and taker fills apart: %v", len(batch), f.label, err)
+			return
+		}
+
+		for id, o := range resp {
+			o.TransactionID = id
+			f.orders[id] = o
+		}
+	}
+}
+
+// orderType tells if a fill added liquidity to the order book (maker) or took it (taker).
+// Krakens maker flag is used if present. For older trades the order decides: post-only orders are always
+// makers, market and stop orders takers and limit orders are takers if they filled the moment they were
+// placed, because then they crossed the book. Without the order, limit orders are assumed to be makers.
+func (f *Fetcher) orderType(r g.TradeRec) proto.OrderType {
+	if r.Maker != nil {
+		if *r.Maker {
+			return proto.OrderType_MAKER
+		}
+		return proto.OrderType_TAKER
+	}
+
+	if o, ok := f.orders[r.TransactionID]; ok {
+		switch {
+		case strings.Contains(o.OrderFlags, "post"):
+			return proto.OrderType_MAKER
+		case o.Description.OrderType != "limit":
+			return proto.OrderType_TAKER
+		}
+
+		placed := o.OpenTime
+		if o.StartTime > placed {
+			placed = o.StartTime
+		}
+
+		if r.Time-placed < 1 {
+			return proto.OrderType_TAKER
+		}
+		return proto.OrderType_MAKER
+	}
+
+	if r.OrderType == "limit" {
+		return proto.OrderType_MAKER
+	}
+
+	return proto.OrderType_TAKER
+}
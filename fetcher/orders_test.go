@@ -0,0 +1,60 @@
+package fetcher
+
+import (
+	"testing"
+
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/krakenapi"
+	"github.com/f-taxes/kraken_import/proto"
+)
+
+func TestOrderType(t *testing.T) {
+	const placed = 1700000000.0
+
+	yes, no := true, false
+
+	limit := func(flags string, openTime, startTime float64) *krakenapi.Order {
+		return &krakenapi.Order{OrderFlags: flags, OpenTime: openTime, StartTime: startTime, Description: krakenapi.OrderDescription{OrderType: "limit"}}
+	}
+
+	tests := []struct {
+		name      string
+		maker     *bool
+		orderType string
+		filled    float64 // Seconds after placed.
+		order     *krakenapi.Order
+		want      proto.OrderType
+	}{
+		{name: "maker flag", maker: &yes, orderType: "market", order: &krakenapi.Order{Description: krakenapi.OrderDescription{OrderType: "market"}}, want: proto.OrderType_MAKER},
+		{name: "taker flag", maker: &no, orderType: "limit", filled: 60, order: limit("post", placed, 0), want: proto.OrderType_TAKER},
+		{name: "post only", orderType: "limit", filled: 0, order: limit("fciq,post", placed, 0), want: proto.OrderType_MAKER},
+		{name: "market order", orderType: "market", filled: 60, order: &krakenapi.Order{OpenTime: placed, Description: krakenapi.OrderDescription{OrderType: "market"}}, want: proto.OrderType_TAKER},
+		{name: "stop loss", orderType: "stop-loss", filled: 60, order: &krakenapi.Order{OpenTime: placed, Description: krakenapi.OrderDescription{OrderType: "stop-loss"}}, want: proto.OrderType_TAKER},
+		{name: "limit filled when placed", orderType: "limit", filled: 0.4, order: limit("fciq", placed, 0), want: proto.OrderType_TAKER},
+		{name: "limit filled a second later", orderType: "limit", filled: 1, order: limit("fciq", placed, 0), want: proto.OrderType_MAKER},
+		{name: "limit filled at its start time", orderType: "limit", filled: 300.5, order: limit("fciq", placed, placed+300), want: proto.OrderType_TAKER},
+		{name: "limit filled after its start time", orderType: "limit", filled: 360, order: limit("fciq", placed, placed+300), want: proto.OrderType_MAKER},
+		{name: "limit without the order", orderType: "limit", want: proto.OrderType_MAKER},
+		{name: "market without the order", orderType: "market", want: proto.OrderType_TAKER},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Fetcher{orders: map[string]krakenapi.Order{}}
+			if tt.order != nil {
+				f.orders["OABCDE-FGHIJ-KLMNOP"] = *tt.order
+			}
+
+			r := g.TradeRec{TradeHistoryInfo: krakenapi.TradeHistoryInfo{
+				TransactionID: "OABCDE-FGHIJ-KLMNOP",
+				Time:          placed + tt.filled,
+				OrderType:     tt.orderType,
+				Maker:         tt.maker,
+			}}
+
+			if got := f.orderType(r); got != tt.want {
+				t.Errorf("orderType() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/krakenapi"
+)
+
+// PermissionCheck is the result of probing a single permission of an API key.
+type PermissionCheck struct {
+	Permission string `json:"permission"`        // Name of the permission as labeled on kraken.com.
+	Method     string `json:"method"`            // Private method that was called to probe the permission.
+	Required   bool   `json:"required"`          // If false the plugin works without the permission, but with less detail.
+	Granted    bool   `json:"granted"`           // True if the probe succeeded.
+	Message    string `json:"message,omitempty"` // Explanation if the probe failed.
+}
+
+// KeyProbe summarizes whether an API key works and which permissions it has.
+type KeyProbe struct {
+	Valid       bool              `json:"valid"`             // False if Kraken rejected the key or its signature.
+	Message     string            `json:"message,omitempty"` // Explanation if the key isn't valid.
+	Permissions []PermissionCheck `json:"permissions"`
+}
+
+// MissingRequired returns true if at least one required permission wasn't granted.
+func (p *KeyProbe) MissingRequired() bool {
+	for _, c := range p.Permissions {
+		if c.Required && !c.Granted {
+			return true
+		}
+	}
+
+	return false
+}
+
+type permissionProbe struct {
+	method   string
+	required bool
+	call     func(ctx context.Context, api *krakenapi.KrakenAPI) error
+}
+
+// Cheap calls that only succeed if the key has the respective permission.
+var permissionProbes = []permissionProbe{
+	{
+		method: "Balance",
+		call: func(ctx context.Context, api *krakenapi.KrakenAPI) error {
+			_, err := api.Balance(ctx)
+			return err
+		},
+	},
+	{
+		method:   "Ledgers",
+		required: true,
+		call: func(ctx context.Context, api *krakenapi.KrakenAPI) error {
+			_, err := api.Ledgers(ctx, map[string]string{"start": strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)})
+			return err
+		},
+	},
+	{
+		method:   "TradesHistory",
+		required: true,
+		call: func(ctx context.Context, api *krakenapi.KrakenAPI) error {
+			_, err := api.TradesHistory(ctx, time.Now().Add(-time.Minute).Unix(), 0, nil)
+			return err
+		},
+	},
+	{
+		method: "QueryOrders",
+		call: func(ctx context.Context, api *krakenapi.KrakenAPI) error {
+			// There is no such order. Kraken answers with "EOrder:Invalid order" if the permission is granted.
+			_, err := api.QueryOrders(ctx, "OAAAAA-AAAAA-AAAAAA", nil)
+			var apiErr *krakenapi.APIError
+			if errors.As(err, &apiErr) && apiErr.Unwrap() == nil {
+				return nil
+			}
+			return err
+		},
+	},
+}
+
+// ProbeKey verifies the signature of the account's API key and checks which of the permissions
+// the plugin relies on are granted.
+func ProbeKey(ctx context.Context, acc g.Account) *KeyProbe {
+	api := newKrakenApi(acc).WithRetryPolicy(krakenapi.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Second, MaxDelay: time.Second})
+	probe := &KeyProbe{Valid: true, Permissions: []PermissionCheck{}}
+
+	for _, p := range permissionProbes {
+		check := PermissionCheck{
+			Permission: krakenapi.RequiredPermission(p.method),
+			Method:     p.method,
+			Required:   p.required,
+		}
+
+		err := p.call(ctx, api)
+
+		switch {
+		case err == nil:
+			check.Granted = true
+		case errors.Is(err, krakenapi.ErrInvalidKey), errors.Is(err, krakenapi.ErrTemporaryLockout), errors.Is(err, krakenapi.ErrInvalidNonce):
+			probe.Valid = false
+			probe.Message = krakenapi.Guidance(err)
+			return probe
+		case errors.Is(err, krakenapi.ErrPermissionDenied):
+			check.Message = fmt.Sprintf("Enable \"%s\" for this key on kraken.com.", check.Permission)
+		default:
+			check.Message = krakenapi.Guidance(err)
+		}
+
+		probe.Permissions = append(probe.Permissions, check)
+	}
+
+	return probe
+}
@@ -1,14 +1,24 @@
 package fetcher
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/f-taxes/kraken_import/conf"
 	g "github.com/f-taxes/kraken_import/global"
 	"github.com/f-taxes/kraken_import/krakenapi"
+	"github.com/kataras/golog"
 	"go.uber.org/ratelimit"
 )
 
@@ -19,15 +29,92 @@ type ProxyApi struct {
 	limiter     ratelimit.Limiter
 }
 
-func NewProxyApi(label, key, secret string) *ProxyApi {
+func NewProxyApi(acc g.Account) *ProxyApi {
 	return &ProxyApi{
-		realApi:     krakenapi.New(key, secret),
-		cacheKey:    label,
+		realApi:     newKrakenApi(acc),
+		cacheKey:    acc.Label,
 		cacheFolder: "./cache",
 		limiter:     ratelimit.New(8, ratelimit.Per(time.Minute)),
 	}
 }
 
+// newKrakenApi creates an api client for the account that uses the endpoint, timeout and proxy from the app config.
+func newKrakenApi(acc g.Account) *krakenapi.KrakenAPI {
+	api := configureApi(krakenapi.New(acc.ApiKey, acc.ApiSecret))
+
+	nonce, err := nonceSource(acc.ApiKey)
+	if err != nil {
+		golog.Errorf("Failed to load the persisted nonce of account %s, falling back to an in-memory nonce: %v", acc.Label, err)
+	} else {
+		api.WithNonceSource(nonce)
+	}
+
+	switch acc.OtpType {
+	case "static":
+		api.WithOTP(krakenapi.StaticOTP(acc.Otp))
+	case "totp":
+		api.WithOTP(krakenapi.TOTP(acc.Otp))
+	}
+
+	return api
+}
+
+// PublicApi returns a client for Kraken's public endpoints (market data) that uses the endpoint, timeout and proxy from the app config.
+func PublicApi() *krakenapi.KrakenAPI {
+	return configureApi(krakenapi.New("", ""))
+}
+
+func configureApi(api *krakenapi.KrakenAPI) *krakenapi.KrakenAPI {
+	api.WithBaseURL(conf.App.String("kraken.baseUrl", krakenapi.APIURL)).
+		WithTimeout(time.Duration(conf.App.Int("kraken.timeout", 30)) * time.Second).
+		WithResponseHook(logResponse)
+
+	if proxy := conf.App.String("kraken.proxy"); proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			golog.Errorf("Ignoring invalid proxy url %s: %v", proxy, err)
+		} else {
+			api.WithProxy(proxyURL)
+		}
+	}
+
+	return api
+}
+
+var (
+	noncesMu sync.Mutex
+	nonces   = map[string]*krakenapi.MonotonicNonce{}
+)
+
+// nonceSource returns the persisted nonce source of an api key. All clients using the same key share it.
+// The file is named after a hash of the key to avoid writing the key itself to disk.
+func nonceSource(key string) (*krakenapi.MonotonicNonce, error) {
+	noncesMu.Lock()
+	defer noncesMu.Unlock()
+
+	if n, ok := nonces[key]; ok {
+		return n, nil
+	}
+
+	hash := sha256.Sum256([]byte(key))
+	n, err := krakenapi.NewMonotonicNonce(filepath.Join("./data", "nonces", hex.EncodeToString(hash[:8])))
+	if err != nil {
+		return nil, err
+	}
+
+	nonces[key] = n
+	return n, nil
+}
+
+func logResponse(req *http.Request, resp *http.Response, elapsed time.Duration, err error) {
+	if err != nil {
+		golog.Debugf("%s %s failed after %s: %v", req.Method, req.URL.Path, elapsed, err)
+		return
+	}
+
+	golog.Debugf("%s %s -> %d (%s)", req.Method, req.URL.Path, resp.StatusCode, elapsed)
+}
+
 func (a *ProxyApi) ensureCache() {
 	os.MkdirAll(a.cacheFolder, 0755)
 }
@@ -54,10 +141,29 @@ func (a *ProxyApi) writeCache(key string, data []byte) error {
 	return nil
 }
 
-func (a *ProxyApi) TradesHistory(start int64, end int64, args map[string]string) (*krakenapi.TradesHistoryResponse, error) {
+// ClearCache removes all cached responses of the account.
+func (a *ProxyApi) ClearCache() error {
+	files, err := filepath.Glob(filepath.Join(a.cacheFolder, a.cacheKey+"_*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TradesHistory loads a page of trades between start and end. Pages of open-ended windows (end = 0) keep growing
+// and are therefore never cached.
+func (a *ProxyApi) TradesHistory(ctx context.Context, start int64, end int64, args map[string]string) (*krakenapi.TradesHistoryResponse, error) {
 	cacheName := fmt.Sprintf("trades_%d_%d_%s", start, end, args["ofs"])
+	cacheable := end != 0
 
-	if cached := a.readCache(cacheName); cached != nil {
+	if cached := a.readCache(cacheName); cacheable && cached != nil {
 		resp := krakenapi.TradesHistoryResponse{}
 		err := json.Unmarshal(cached, &resp)
 		if err != nil {
@@ -68,9 +174,9 @@ func (a *ProxyApi) TradesHistory(start int64, end int64, args map[string]string)
 	}
 
 	a.limiter.Take()
-	resp, err := a.realApi.TradesHistory(start, end, args)
+	resp, err := a.realApi.TradesHistory(ctx, start, end, args)
 
-	if err == nil {
+	if err == nil && cacheable {
 		data, err := json.Marshal(*resp)
 		if err != nil {
 			return nil, err
@@ -81,10 +187,12 @@ func (a *ProxyApi) TradesHistory(start int64, end int64, args map[string]string)
 	return resp, err
 }
 
-func (a *ProxyApi) Ledgers(args map[string]string) (map[string]g.LedgerInfoDoc, error) {
-	cacheName := fmt.Sprintf("ledgers_%s_%s", args["start"], args["ofs"])
+// Ledgers loads a page of ledger entries. Like trades, pages of open-ended windows are never cached.
+func (a *ProxyApi) Ledgers(ctx context.Context, args map[string]string) (map[string]g.LedgerInfoDoc, error) {
+	end, cacheable := args["end"]
+	cacheName := fmt.Sprintf("ledgers_%s_%s_%s", args["start"], end, args["ofs"])
 
-	if cached := a.readCache(cacheName); cached != nil {
+	if cached := a.readCache(cacheName); cacheable && cached != nil {
 		resp := map[string]g.LedgerInfoDoc{}
 		err := json.Unmarshal(cached, &resp)
 		if err != nil {
@@ -95,7 +203,7 @@ func (a *ProxyApi) Ledgers(args map[string]string) (map[string]g.LedgerInfoDoc,
 	}
 
 	a.limiter.Take()
-	resp, err := a.realApi.Ledgers(args)
+	resp, err := a.realApi.Ledgers(ctx, args)
 	recs := map[string]g.LedgerInfoDoc{}
 
 	if err == nil {
@@ -105,6 +213,7 @@ func (a *ProxyApi) Ledgers(args map[string]string) (map[string]g.LedgerInfoDoc,
 				RefID:   entry.RefID,
 				Time:    entry.Time,
 				Type:    entry.Type,
+				Subtype: entry.Subtype,
 				Aclass:  entry.Aclass,
 				Asset:   entry.Asset,
 				Amount:  entry.Amount.Text('f', 8),
@@ -113,12 +222,100 @@ func (a *ProxyApi) Ledgers(args map[string]string) (map[string]g.LedgerInfoDoc,
 			}
 		}
 
-		data, err := json.Marshal(recs)
+		if cacheable {
+			data, err := json.Marshal(recs)
+			if err != nil {
+				return nil, err
+			}
+			a.writeCache(cacheName, data)
+		}
+	}
+
+	return recs, err
+}
+
+// QueryOrders loads the given orders. Closed orders don't change anymore, so the responses are cached.
+func (a *ProxyApi) QueryOrders(ctx context.Context, txids []string) (krakenapi.QueryOrdersResponse, error) {
+	hash := sha256.Sum256([]byte(strings.Join(txids, ",")))
+	cacheName := fmt.Sprintf("orders_%s", hex.EncodeToString(hash[:8]))
+
+	if cached := a.readCache(cacheName); cached != nil {
+		resp := krakenapi.QueryOrdersResponse{}
+		err := json.Unmarshal(cached, &resp)
 		if err != nil {
 			return nil, err
 		}
-		a.writeCache(cacheName, data)
+
+		return resp, nil
 	}
 
-	return recs, err
+	a.limiter.Take()
+	resp, err := a.realApi.QueryOrders(ctx, strings.Join(txids, ","), map[string]string{"trades": "true"})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(*resp)
+	if err != nil {
+		return nil, err
+	}
+	a.writeCache(cacheName, data)
+
+	return *resp, nil
+}
+
+// TransferStatus loads the deposits (or withdrawals) of an asset between start and end. Responses are only cached
+// once all transfers in them reached their final state.
+func (a *ProxyApi) TransferStatus(ctx context.Context, withdrawals bool, asset string, start, end int64) ([]krakenapi.TransferStatus, error) {
+	kind := "deposits"
+	if withdrawals {
+		kind = "withdrawals"
+	}
+	cacheName := fmt.Sprintf("%s_%s_%d_%d", kind, asset, start, end)
+
+	if cached := a.readCache(cacheName); cached != nil {
+		resp := []krakenapi.TransferStatus{}
+		err := json.Unmarshal(cached, &resp)
+		if err != nil {
+			return nil, err
+		}
+
+		return resp, nil
+	}
+
+	args := map[string]string{
+		"asset": asset,
+		"start": strconv.FormatInt(start, 10),
+		"end":   strconv.FormatInt(end, 10),
+	}
+
+	var resp []krakenapi.TransferStatus
+	a.limiter.Take()
+	if withdrawals {
+		r, err := a.realApi.WithdrawStatus(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		resp = *r
+	} else {
+		r, err := a.realApi.DepositStatus(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		resp = *r
+	}
+
+	for _, s := range resp {
+		if !s.Final() {
+			return resp, nil
+		}
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	a.writeCache(cacheName, data)
+
+	return resp, nil
 }
@@ -0,0 +1,438 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/store"
+	"github.com/kataras/golog"
+)
+
+const registryKey = "registry"
+
+// refreshInterval is how long live data is considered fresh before it's loaded again.
+const refreshInterval = time.Hour
+
+// maxRegistryChanges is how many of the most recent changes the change log keeps.
+const maxRegistryChanges = 2000
+
+// Origins of the entries in the registry.
+const (
+	OriginLive     = "live"     // Listed by the Assets or AssetPairs endpoint.
+	OriginSnapshot = "snapshot" // Taken from the snapshot bundled with the plugin.
+	OriginLearned  = "learned"  // Derived from ledger entries, e.g. of delisted markets.
+)
+
+type RegistryAsset struct {
+	AssetInfo
+	Origin   string `json:"origin"`
+	Delisted bool   `json:"delisted,omitempty"`
+}
+
+type RegistryPair struct {
+	PairInfo
+	Origin   string `json:"origin"`
+	Delisted bool   `json:"delisted,omitempty"`
+
+	// When a refresh found the pair listed or delisted. Zero if it was listed before the registry first
+	// saw live data, or if it hasn't been delisted.
+	ListedAt   time.Time `json:"listedAt,omitempty"`
+	DelistedAt time.Time `json:"delistedAt,omitempty"`
+}
+
+// Listing is the span a pair was traded in, as far as the registry knows. Zero times are open ends.
+type Listing struct {
+	From, Until time.Time
+}
+
+// Contains reports if the pair was listed at t.
+func (l Listing) Contains(t time.Time) bool {
+	return (l.From.IsZero() || !t.Before(l.From)) && (l.Until.IsZero() || t.Before(l.Until))
+}
+
+// RegistryChange records how an entry changed with a version of the registry.
+type RegistryChange struct {
+	Version int       `json:"version"`
+	Ts      time.Time `json:"ts"`
+	Kind    string    `json:"kind"` // asset or pair
+	Key     string    `json:"key"`
+	Change  string    `json:"change"` // added, delisted, relisted or learned
+}
+
+// Registry keeps every asset and pair ever seen, so records of delisted markets can still be processed.
+// It merges the live api data with the bundled snapshot and pairs learned from ledger entries.
+// Every change increases the version and is kept in the change log, up to maxRegistryChanges.
+type Registry struct {
+	mu sync.RWMutex
+	db *badger.DB
+
+	Version   int                      `json:"version"`
+	Refreshed time.Time                `json:"refreshed"`
+	Assets    map[string]RegistryAsset `json:"assets"`
+	Pairs     map[string]RegistryPair  `json:"pairs"`
+	Changes   []RegistryChange         `json:"changes"`
+}
+
+// Reg is the registry of the plugin, set up by InitRegistry.
+var Reg *Registry
+
+// InitRegistry loads the registry from the database and adds the assets of the bundled snapshot
+// (the response of krakens Assets endpoint) that it doesn't know yet.
+func InitRegistry(db *badger.DB, snapshot []byte) error {
+	r := &Registry{
+		db:     db,
+		Assets: map[string]RegistryAsset{},
+		Pairs:  map[string]RegistryPair{},
+	}
+
+	err := db.View(func(txn *badger.Txn) error {
+		return store.GetJSON(txn, registryKey, r)
+	})
+	if err != nil && !store.IsNotFound(err) {
+		return err
+	}
+
+	if len(snapshot) > 0 {
+		resp := AssetsResponse{}
+		if err := json.Unmarshal(snapshot, &resp); err != nil {
+			return fmt.Errorf("failed to parse the bundled asset snapshot: %w", err)
+		}
+
+		changes := []RegistryChange{}
+		for key, a := range resp.Result {
+			if _, ok := r.Assets[key]; !ok {
+				r.Assets[key] = RegistryAsset{AssetInfo: a, Origin: OriginSnapshot}
+				changes = append(changes, RegistryChange{Kind: "asset", Key: key, Change: "added"})
+			}
+		}
+
+		if err := r.commit(changes); err != nil {
+			return err
+		}
+	}
+
+	Reg = r
+	return nil
+}
+
+// commit stores the registry as a new version if there are changes. The caller must hold the write lock
+// or have exclusive access.
+func (r *Registry) commit(changes []RegistryChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Kind+changes[i].Key < changes[j].Kind+changes[j].Key
+	})
+
+	r.Version++
+	now := time.Now().UTC()
+	for i := range changes {
+		changes[i].Version = r.Version
+		changes[i].Ts = now
+	}
+	r.Changes = append(r.Changes, changes...)
+	if n := len(r.Changes) - maxRegistryChanges; n > 0 {
+		r.Changes = append([]RegistryChange{}, r.Changes[n:]...)
+	}
+
+	return r.db.Update(func(txn *badger.Txn) error {
+		return store.PutJSON(txn, registryKey, r)
+	})
+}
+
+// Refresh merges the live assets and pairs into the registry. Entries that aren't listed anymore are
+// marked as delisted but kept. Live data is only loaded once per refreshInterval unless force is set.
+// If kraken can't be reached, the known entries are used as long as there are any.
+func (r *Registry) Refresh(ctx context.Context, force bool) error {
+	r.mu.RLock()
+	fresh := !force && time.Since(r.Refreshed) < refreshInterval
+	r.mu.RUnlock()
+
+	if fresh {
+		return nil
+	}
+
+	assets, pairs, err := loadLive(ctx)
+	if err != nil {
+		r.mu.RLock()
+		known := len(r.Assets) > 0
+		r.mu.RUnlock()
+
+		if known {
+			golog.Warnf("Failed to load krakens assets and pairs, using the %d known assets and %d pairs instead: %v", len(r.Assets), len(r.Pairs), err)
+			return nil
+		}
+
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changes := []RegistryChange{}
+
+	for key, a := range assets {
+		old, ok := r.Assets[key]
+		switch {
+		case !ok:
+			changes = append(changes, RegistryChange{Kind: "asset", Key: key, Change: "added"})
+		case old.Delisted:
+			changes = append(changes, RegistryChange{Kind: "asset", Key: key, Change: "relisted"})
+		}
+		r.Assets[key] = RegistryAsset{AssetInfo: a, Origin: OriginLive}
+	}
+
+	for key, a := range r.Assets {
+		if _, ok := assets[key]; !ok && !a.Delisted && a.Origin == OriginLive {
+			a.Delisted = true
+			r.Assets[key] = a
+			changes = append(changes, RegistryChange{Kind: "asset", Key: key, Change: "delisted"})
+		}
+	}
+
+	// A pair that shows up on a later refresh was listed after the previous one, a pair that is gone
+	// was delisted before this one.
+	now := time.Now().UTC()
+
+	for key, p := range pairs {
+		old, ok := r.Pairs[key]
+		listedAt := old.ListedAt
+		switch {
+		case !ok:
+			changes = append(changes, RegistryChange{Kind: "pair", Key: key, Change: "added"})
+			listedAt = r.Refreshed
+		case old.Delisted:
+			changes = append(changes, RegistryChange{Kind: "pair", Key: key, Change: "relisted"})
+		}
+		r.Pairs[key] = RegistryPair{PairInfo: p, Origin: OriginLive, ListedAt: listedAt}
+	}
+
+	for key, p := range r.Pairs {
+		if _, ok := pairs[key]; !ok && !p.Delisted && p.Origin == OriginLive {
+			p.Delisted = true
+			p.DelistedAt = now
+			r.Pairs[key] = p
+			changes = append(changes, RegistryChange{Kind: "pair", Key: key, Change: "delisted"})
+		}
+	}
+
+	r.Refreshed = now
+
+	if len(changes) == 0 {
+		return r.db.Update(func(txn *badger.Txn) error {
+			return store.PutJSON(txn, registryKey, r)
+		})
+	}
+
+	return r.commit(changes)
+}
+
+func loadLive(ctx context.Context) (map[string]AssetInfo, map[string]PairInfo, error) {
+	api := PublicApi()
+	assets := map[string]AssetInfo{}
+	pairs := map[string]PairInfo{}
+
+	limiter.Take()
+	if err := api.QueryPublicInto(ctx, "Assets", nil, &assets); err != nil {
+		return nil, nil, err
+	}
+
+	limiter.Take()
+	if err := api.QueryPublicInto(ctx, "AssetPairs", nil, &pairs); err != nil {
+		return nil, nil, err
+	}
+
+	return assets, pairs, nil
+}
+
+// Market returns copies of all known assets and pairs, including delisted ones.
+func (r *Registry) Market() (map[string]AssetInfo, map[string]PairInfo) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	assets := make(map[string]AssetInfo, len(r.Assets))
+	for key, a := range r.Assets {
+		assets[key] = a.AssetInfo
+	}
+
+	pairs := make(map[string]PairInfo, len(r.Pairs))
+	for key, p := range r.Pairs {
+		pairs[key] = p.PairInfo
+	}
+
+	return assets, pairs
+}
+
+// Listings returns when the known pairs were listed.
+func (r *Registry) Listings() map[string]Listing {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	listings := make(map[string]Listing, len(r.Pairs))
+	for key, p := range r.Pairs {
+		listings[key] = Listing{From: p.ListedAt, Until: p.DelistedAt}
+	}
+
+	return listings
+}
+
+// Altname returns the altname of an asset given by its key or altname.
+func (r *Registry) Altname(name string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if a, ok := r.Assets[name]; ok && a.Altname != "" {
+		return a.Altname, true
+	}
+
+	for _, a := range r.Assets {
+		if a.Altname == name {
+			return a.Altname, true
+		}
+	}
+
+	return "", false
+}
+
+// LearnAsset adds an asset that only appears in ledger entries. Its decimals are guessed from the amounts.
+func (r *Registry) LearnAsset(key string, decimals int) (AssetInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if a, ok := r.Assets[key]; ok {
+		return a.AssetInfo, nil
+	}
+
+	a := AssetInfo{Aclass: "currency", Altname: key, Decimals: decimals, DisplayDecimals: decimals, Status: "delisted"}
+	r.Assets[key] = RegistryAsset{AssetInfo: a, Origin: OriginLearned, Delisted: true}
+
+	return a, r.commit([]RegistryChange{{Kind: "asset", Key: key, Change: "learned"}})
+}
+
+// LearnPair adds a pair that only appears in the trade history, with the base and quote asset found in
+// the trade's ledger entries.
+func (r *Registry) LearnPair(key, base, quote string) (PairInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p, ok := r.Pairs[key]; ok {
+		return p.PairInfo, nil
+	}
+
+	altname := func(asset string) string {
+		if a, ok := r.Assets[asset]; ok && a.Altname != "" {
+			return a.Altname
+		}
+		return asset
+	}
+
+	p := PairInfo{
+		Altname:     key,
+		Wsname:      altname(base) + "/" + altname(quote),
+		Base:        base,
+		Quote:       quote,
+		AclassBase:  "currency",
+		AclassQuote: "currency",
+		Status:      "delisted",
+	}
+	r.Pairs[key] = RegistryPair{PairInfo: p, Origin: OriginLearned, Delisted: true}
+
+	return p, r.commit([]RegistryChange{{Kind: "pair", Key: key, Change: "learned"}})
+}
+
+// splitPair finds the base and quote asset of a pair name by trying all known asset keys and altnames,
+// e.g. XXBTZEUR or DOTEUR.
+func (r *Registry) splitPair(name string) (base, quote string, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	byName := map[string]string{}
+	for key, a := range r.Assets {
+		byName[key] = key
+		if _, taken := byName[a.Altname]; !taken {
+			byName[a.Altname] = key
+		}
+	}
+
+	for i := 1; i < len(name); i++ {
+		b, bok := byName[name[:i]]
+		q, qok := byName[name[i:]]
+		if bok && qok {
+			return b, q, true
+		}
+	}
+
+	return "", "", false
+}
+
+var errUnknownPair = errors.New("unknown pair")
+
+// learnPair derives an unknown pair of a trade from its ledger entries: for a buy the base asset is the one
+// that was received, for a sell the one that was spent. If the ledger entries aren't available, the pair's
+// name is split into known assets.
+func (f *Fetcher) learnPair(r g.TradeRec) (PairInfo, error) {
+	base, quote := "", ""
+
+	for _, l := range r.LedgerRecs {
+		if l.Type != "trade" && l.Type != "margin" {
+			continue
+		}
+
+		received := !strings.HasPrefix(strings.TrimSpace(l.Amount), "-")
+		if received == (r.Type == "buy") {
+			base = l.Asset
+		} else {
+			quote = l.Asset
+		}
+	}
+
+	if base == "" || quote == "" || base == quote {
+		var ok bool
+		if base, quote, ok = Reg.splitPair(r.AssetPair); !ok {
+			return PairInfo{}, fmt.Errorf("%w %s", errUnknownPair, r.AssetPair)
+		}
+	}
+
+	p, err := Reg.LearnPair(r.AssetPair, base, quote)
+	if err != nil {
+		return p, err
+	}
+
+	f.pairs[r.AssetPair] = p
+	return p, nil
+}
+
+// learnAssets adds the assets of ledger entries that neither the api nor the snapshot know.
+func (f *Fetcher) learnAssets(recs []g.LedgerRec) error {
+	for _, l := range recs {
+		if _, ok := f.assets[l.Asset]; ok || l.Asset == "" {
+			continue
+		}
+
+		decimals := 0
+		for _, v := range []string{l.Amount, l.Fee, l.Balance} {
+			if _, frac, ok := strings.Cut(v, "."); ok && len(frac) > decimals {
+				decimals = len(frac)
+			}
+		}
+
+		a, err := Reg.LearnAsset(l.Asset, decimals)
+		if err != nil {
+			return err
+		}
+
+		f.assets[l.Asset] = a
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/grpc_client"
+	"github.com/f-taxes/kraken_import/krakenapi"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/kataras/golog"
+)
+
+// canReverse reports if an entry is of a type that is booked for a transfer or for undoing one.
+func canReverse(r g.LedgerRec) bool {
+	switch r.Type {
+	case "deposit", "withdrawal", "adjustment", "transfer":
+		return true
+	}
+	return false
+}
+
+// isReversal reports if an entry undoes a transfer on its own: a withdrawal that credits or a deposit that debits
+// the account.
+func isReversal(r g.LedgerRec) bool {
+	amount := g.StrToDecimal(r.Amount)
+	return (r.Type == "withdrawal" && amount.IsPositive()) || (r.Type == "deposit" && amount.IsNegative())
+}
+
+// findReversals collects the entries among recs that undo a cancelled, failed or returned transfer. These are entries
+// that credit what a withdrawal took (or take back what a deposit credited) under the same reference id.
+// recs has to hold all entries of a fetch, as the reversal and the transfer it undoes may be on different pages.
+func (f *Fetcher) findReversals(recs []g.LedgerRec) {
+	if f.reversals == nil {
+		f.reversals = map[string]g.LedgerRec{}
+		f.reversed = map[string]bool{}
+	}
+
+	byRef := map[string][]g.LedgerRec{}
+	for _, r := range recs {
+		if canReverse(r) {
+			byRef[r.RefID] = append(byRef[r.RefID], r)
+		}
+	}
+
+	for refID, group := range byRef {
+		sort.Slice(group, func(i, j int) bool { return group[i].Time < group[j].Time })
+
+		for _, r := range group {
+			if isReversal(r) {
+				f.reversals[refID] = r
+				break
+			}
+		}
+
+		if _, ok := f.reversals[refID]; ok || len(group) < 2 || (group[0].Type != "deposit" && group[0].Type != "withdrawal") {
+			continue
+		}
+
+		original := g.StrToDecimal(group[0].Amount)
+		for _, r := range group[1:] {
+			if g.StrToDecimal(r.Amount).Sign() == -original.Sign() {
+				f.reversals[refID] = r
+				break
+			}
+		}
+	}
+}
+
+// reversalOf returns the entry that reverses the transfer of r, if there is one. ok is false for r itself.
+func (f *Fetcher) reversalOf(r g.LedgerRec) (rev g.LedgerRec, ok bool) {
+	rev, ok = f.reversals[r.RefID]
+	return rev, ok && rev.ID != r.ID
+}
+
+// isKnownReversal reports if r is an entry that reverses a transfer.
+func (f *Fetcher) isKnownReversal(r g.LedgerRec) bool {
+	rev, ok := f.reversals[r.RefID]
+	return ok && rev.ID == r.ID
+}
+
+// reversalReason tells why a transfer was reversed, as far as Kraken reports it in the transfer's status.
+func (f *Fetcher) reversalReason(refID string) string {
+	st := f.transferStatus[refID]
+
+	switch {
+	case st.StatusProp == krakenapi.TransferCanceled || st.StatusProp == krakenapi.TransferCancelPending:
+		return "cancelled"
+	case st.StatusProp == krakenapi.TransferReturn:
+		return "returned"
+	case st.Status == krakenapi.TransferFailure:
+		return "failed"
+	}
+	return "reversed"
+}
+
+// skipReversed drops a transfer whose reversal is part of the same fetch, as together they have no effect.
+func (f *Fetcher) skipReversed(r g.LedgerRec) bool {
+	if _, ok := f.reversalOf(r); !ok {
+		return false
+	}
+
+	f.reversed[r.RefID] = true
+	golog.Infof("Skipping %s %s of %s %s, it was %s by Kraken (RefID = %s)", r.Type, r.ID, r.Amount, r.Asset, f.reversalReason(r.RefID), r.RefID)
+	return true
+}
+
+// submitReversals submits the reversals of transfers that were submitted by an earlier fetch as transfers in the
+// opposite direction, so the two net out. Reversals of transfers that were skipped by this fetch are dropped.
+func (f *Fetcher) submitReversals(ctx context.Context) (int, error) {
+	count := 0
+
+	pending := []g.LedgerRec{}
+	for refID, r := range f.reversals {
+		if !f.reversed[refID] {
+			pending = append(pending, r)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Time < pending[j].Time })
+
+	for _, r := range pending {
+		refID := r.RefID
+
+		transfer := f.ledgerTransfer(r)
+
+		if g.StrToDecimal(r.Amount).IsPositive() {
+			transfer.Action = proto.TransferAction_DEPOSIT
+			transfer.Source = "Kraken"
+			transfer.Destination = f.label
+			transfer.Comment = fmt.Sprintf("Withdrawal %s was %s by Kraken, the funds have been credited back", refID, f.reversalReason(refID))
+		} else {
+			transfer.Action = proto.TransferAction_WITHDRAWAL
+			transfer.Source = f.label
+			transfer.Destination = "Kraken"
+			transfer.Comment = fmt.Sprintf("Deposit %s was %s by Kraken, the funds have been taken back", refID, f.reversalReason(refID))
+		}
+
+		if err := f.sink.SubmitTransfer(ctx, transfer); err != nil {
+			return count, err
+		}
+		count++
+
+		grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_INFO, Message: fmt.Sprintf("[%s] %s", g.Plugin.Label, transfer.Comment)})
+	}
+
+	return count, nil
+}
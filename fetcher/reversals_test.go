@@ -0,0 +1,64 @@
+package fetcher
+
+import (
+	"context"
+	"testing"
+
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/proto"
+)
+
+func TestReversalsAcrossPages(t *testing.T) {
+	f, s := setupFetcher(t, "Kraken")
+	f.restClient = replayApi(t, map[string]string{
+		"/0/private/DepositStatus":  "testdata/deposit_status.json",
+		"/0/private/WithdrawStatus": "testdata/withdraw_status.json",
+	})
+
+	// Newest first: the reversals are on the first page, the transfers they undo on the second one.
+	pages := [][]g.LedgerRec{
+		readLedger(t, `[
+			{"refid": "W-CANCEL", "time": 1700003600, "type": "withdrawal", "aclass": "currency", "asset": "XETH", "amount": "1.0000000000", "fee": "0", "ID": "L-W-BACK"},
+			{"refid": "D-RETURN", "time": 1700003500, "type": "adjustment", "aclass": "currency", "asset": "XXBT", "amount": "-0.2000000000", "fee": "0", "ID": "L-D-BACK"},
+			{"refid": "W-EARLIER", "time": 1700003400, "type": "withdrawal", "aclass": "currency", "asset": "XETH", "amount": "0.5000000000", "fee": "0", "ID": "L-E-BACK"}
+		]`),
+		readLedger(t, `[
+			{"refid": "D-RETURN", "time": 1700000100, "type": "deposit", "aclass": "currency", "asset": "XXBT", "amount": "0.2000000000", "fee": "0", "ID": "L-D"},
+			{"refid": "W-CANCEL", "time": 1700000000, "type": "withdrawal", "aclass": "currency", "asset": "XETH", "amount": "-1.0000000000", "fee": "0", "ID": "L-W"},
+			{"refid": "KEEP", "time": 1699999000, "type": "deposit", "aclass": "currency", "asset": "XXBT", "amount": "0.1000000000", "fee": "0", "ID": "L-KEEP"}
+		]`),
+	}
+
+	for _, recs := range pages {
+		if err := f.collectLedgerRecs(context.Background(), recs, map[string][]g.LedgerRec{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := f.submitLedgerTransfers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("submitted %d transfers, want 1", n)
+	}
+
+	if _, err := f.submitAdjustments(context.Background(), f.adjustments); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the reversal of a withdrawal from an earlier fetch is left.
+	if _, err := f.submitReversals(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.transfers) != 2 {
+		t.Fatalf("submitted %v, want L-KEEP and L-E-BACK", s.transfers)
+	}
+	if s.transfers[0].TxID != "L-KEEP" || s.transfers[0].Action != proto.TransferAction_DEPOSIT {
+		t.Errorf("unexpected transfer %v", s.transfers[0])
+	}
+	if s.transfers[1].TxID != "L-E-BACK" || s.transfers[1].Action != proto.TransferAction_DEPOSIT {
+		t.Errorf("unexpected reversal %v", s.transfers[1])
+	}
+}
@@ -0,0 +1,135 @@
+package fetcher
+
+import (
+	"context"
+	"time"
+
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/outbox"
+	"github.com/f-taxes/kraken_import/preview"
+	"github.com/f-taxes/kraken_import/sink"
+)
+
+// Window limits a fetch to a time range.
+type Window struct {
+	Since time.Time // Zero continues where the last fetch of the account ended.
+	Until time.Time // Zero fetches everything up to now.
+}
+
+// batch is where a run writes its records to: an outbox batch or, for dry runs, a preview.
+type batch interface {
+	sink.Sink
+	Commit(ctx context.Context) error
+	Abort()
+}
+
+// Fetch submits the ledger entries and trades between since and until to the fetcher's sink.
+func (f *Fetcher) Fetch(ctx context.Context, since, until time.Time) error {
+	ledgerRecs, err := f.Ledger(ctx, since, until)
+	if err != nil {
+		return err
+	}
+
+	// Fills are combined per window of their order (see mergeFills). Trades are fetched from the start of the window
+	// since falls into, so a combined trade that was submitted by the previous fetch is replaced with all its fills.
+	tradesSince := since
+	if aggregateFills() {
+		tradesSince = fillWindowStart(since, aggregateWindow())
+	}
+
+	if tradesSince.Before(since) {
+		earlier, err := f.ledgerEntries(ctx, tradesSince, since, nil)
+		if err != nil {
+			return err
+		}
+		ledgerRecs = append(ledgerRecs, earlier...)
+	}
+
+	return f.Trades(ctx, tradesSince, until, ledgerRecs)
+}
+
+// Run fetches an account and queues its records in the outbox. If the window continues where the last fetch ended,
+// the outbox advances the account's fetch time once all records have been delivered.
+// outbox.ErrQueued is returned if the records couldn't be delivered right away.
+func Run(ctx context.Context, ob *outbox.Outbox, acc g.Account, w Window) error {
+	return runBatch(ctx, acc, w, outboxBatch(ctx, ob, acc), func(f *Fetcher, since, until time.Time) error {
+		return f.Fetch(ctx, since, until)
+	})
+}
+
+// Preview runs a fetch without delivering anything. The records are kept in a preview until it is committed or discarded.
+func Preview(ctx context.Context, ps *preview.Store, acc g.Account, w Window) (*preview.Batch, error) {
+	var pb *preview.Batch
+
+	err := runBatch(ctx, acc, w, previewBatch(ps, acc, "fetch", &pb), func(f *Fetcher, since, until time.Time) error {
+		return f.Fetch(ctx, since, until)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pb, nil
+}
+
+func outboxBatch(ctx context.Context, ob *outbox.Outbox, acc g.Account) func(windowEnd time.Time) (batch, error) {
+	return func(windowEnd time.Time) (batch, error) {
+		// Records of an earlier fetch that couldn't be delivered go first, so the core receives them in order.
+		if err := ob.Flush(ctx, acc.ID); err != nil {
+			return nil, err
+		}
+
+		return ob.Begin(acc.ID, windowEnd)
+	}
+}
+
+func previewBatch(ps *preview.Store, acc g.Account, source string, pb **preview.Batch) func(windowEnd time.Time) (batch, error) {
+	return func(windowEnd time.Time) (batch, error) {
+		b, err := ps.Begin(acc.ID, acc.Label, source, windowEnd)
+		*pb = b
+		return b, err
+	}
+}
+
+// runBatch runs fn with a fetcher that writes to a new batch and commits the batch if fn succeeds.
+func runBatch(ctx context.Context, acc g.Account, w Window, begin func(windowEnd time.Time) (batch, error), fn func(f *Fetcher, since, until time.Time) error) error {
+	since := w.Since
+	windowEnd := time.Time{}
+
+	if since.IsZero() {
+		since, _ = time.Parse(time.RFC3339Nano, acc.LastFetched)
+		windowEnd = w.Until
+		if windowEnd.IsZero() {
+			windowEnd = time.Now().UTC()
+		}
+	}
+
+	b, err := begin(windowEnd)
+	if err != nil {
+		return err
+	}
+
+	f, err := New(ctx, acc, b)
+	if err != nil {
+		b.Abort()
+		return err
+	}
+
+	// Fetches that continue at the cursor end where the outbox will move it to, so the window has a fixed end.
+	until := w.Until
+	if until.IsZero() {
+		until = windowEnd
+	}
+
+	if err := fn(f, since, until); err != nil {
+		b.Abort()
+		return err
+	}
+
+	return b.Commit(ctx)
+}
+
+// ClearCache removes the cached api responses of an account, so the next fetch requests everything from Kraken again.
+func ClearCache(acc g.Account) error {
+	return NewProxyApi(acc).ClearCache()
+}
@@ -0,0 +1,142 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/f-taxes/kraken_import/conf"
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/grpc_client"
+	"github.com/f-taxes/kraken_import/krakenapi"
+	"github.com/f-taxes/kraken_import/proto"
+)
+
+// statusSlack widens the span transfer statuses are loaded for, as a deposit is credited to the ledger some
+// time after kraken registered it.
+const statusSlack = 24 * 60 * 60
+
+// enrichTransfers reports if deposits and withdrawals are enriched with their method, address and blockchain txid.
+func enrichTransfers() bool {
+	return conf.App != nil && conf.App.Bool("transfers.enrich", true)
+}
+
+// loadTransferStatus loads the status of the deposits and withdrawals among recs, by asset and for the time span
+// they cover. If transfers aren't enriched, only withdrawals are loaded and only if there are labeled addresses, so
+// withdrawals to them still get their label. Failures are only logged, the transfers are submitted without the
+// details then.
+func (f *Fetcher) loadTransferStatus(ctx context.Context, recs []g.LedgerRec) {
+	enrich := enrichTransfers()
+	if !enrich && len(f.addresses) == 0 {
+		return
+	}
+
+	if f.transferStatus == nil {
+		f.transferStatus = map[string]krakenapi.TransferStatus{}
+		f.statusDenied = map[bool]bool{}
+	}
+
+	type span struct{ start, end int64 }
+	spans := map[bool]map[string]*span{true: {}, false: {}}
+
+	for _, r := range recs {
+		if r.Type != "withdrawal" && (r.Type != "deposit" || !enrich) {
+			continue
+		}
+		if _, ok := f.transferStatus[r.RefID]; ok {
+			continue
+		}
+
+		ts := int64(r.Time)
+		s := spans[r.Type == "withdrawal"][r.Asset]
+		if s == nil {
+			spans[r.Type == "withdrawal"][r.Asset] = &span{ts, ts}
+			continue
+		}
+		s.start = min(s.start, ts)
+		s.end = max(s.end, ts)
+	}
+
+	for withdrawals, assets := range spans {
+		for asset, s := range assets {
+			if f.statusDenied[withdrawals] {
+				break
+			}
+
+			statuses, err := f.restClient.TransferStatus(ctx, withdrawals, asset, s.start-statusSlack, s.end+statusSlack)
+			if errors.Is(err, krakenapi.ErrPermissionDenied) {
+				f.statusDenied[withdrawals] = true
+				method := "DepositStatus"
+				if withdrawals {
+					method = "WithdrawStatus"
+				}
+				missing := "txid and address"
+				if !enrich {
+					missing = "the labels of the address book"
+				}
+				grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_WARN, Message: fmt.Sprintf("[%s] Transfers of %s are imported without %s. Enable \"%s\" for this key on kraken.com to include them.", g.Plugin.Label, f.label, missing, krakenapi.RequiredPermission(method))})
+				break
+			}
+			if err != nil {
+				grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_WARN, Message: fmt.Sprintf("[%s] Failed to load the status of %s transfers from %s: %s", g.Plugin.Label, normalizeCurrency(asset, f.currencies), f.label, err)})
+				continue
+			}
+
+			for _, st := range statuses {
+				f.transferStatus[st.RefID] = st
+			}
+		}
+	}
+}
+
+// enrichTransfer adds the method, network, address and blockchain txid kraken reports for the transfer of r.
+// The address of a withdrawal is its destination, unless the user labeled it in the address book. The address of a
+// deposit belongs to kraken and is only noted. If transfers aren't enriched, only the label is applied.
+func (f *Fetcher) enrichTransfer(transfer *proto.Transfer, r g.LedgerRec) {
+	st, ok := f.transferStatus[r.RefID]
+	if !ok {
+		return
+	}
+
+	enrich := enrichTransfers()
+
+	if transfer.Action == proto.TransferAction_WITHDRAWAL {
+		if label := f.destinationLabel(st); label != "" {
+			transfer.Destination = label
+		} else if enrich && st.Info != "" {
+			transfer.Destination = st.Info
+		}
+	}
+
+	if enrich {
+		transfer.Comment = transferComment(st)
+	}
+}
+
+// transferComment describes a transfer, e.g. "Ether via Arbitrum One, txid 0x4f1d..., address 0x71c7..., status Success".
+func transferComment(st krakenapi.TransferStatus) string {
+	parts := []string{}
+	switch {
+	case st.Network != "" && st.Network != st.Method:
+		parts = append(parts, fmt.Sprintf("%s via %s", st.Method, st.Network))
+	case st.Method != "":
+		parts = append(parts, st.Method)
+	}
+	if st.TxID != "" {
+		parts = append(parts, "txid "+st.TxID)
+	}
+	if st.Info != "" {
+		parts = append(parts, "address "+st.Info)
+	}
+
+	if st.Status != "" {
+		status := "status " + st.Status
+		if st.StatusProp != "" {
+			status += fmt.Sprintf(" (%s)", st.StatusProp)
+		}
+		parts = append(parts, status)
+	}
+
+	return strings.Join(parts, ", ")
+}
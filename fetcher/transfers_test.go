@@ -0,0 +1,119 @@
+package fetcher
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/krakenapi"
+	"go.uber.org/ratelimit"
+)
+
+// replayApi serves the recorded responses in testdata by method name.
+func replayApi(t *testing.T, fixtures map[string]string) *ProxyApi {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := fixtures[r.URL.Path]
+		if !ok {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		if form.Get("asset") == "" || form.Get("start") == "" || form.Get("end") == "" {
+			t.Errorf("%s requested without asset, start or end: %v", r.URL.Path, form)
+		}
+
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+
+	return &ProxyApi{
+		realApi:     krakenapi.New("key", "c2VjcmV0").WithBaseURL(srv.URL),
+		cacheKey:    "test",
+		cacheFolder: t.TempDir(),
+		limiter:     ratelimit.NewUnlimited(),
+	}
+}
+
+func TestEnrichTransfers(t *testing.T) {
+	f, s := setupFetcher(t, "Kraken")
+	f.restClient = replayApi(t, map[string]string{
+		"/0/private/DepositStatus":  "testdata/deposit_status.json",
+		"/0/private/WithdrawStatus": "testdata/withdraw_status.json",
+	})
+
+	recs := readLedger(t, `[
+		{"refid": "FTQcuak-YdFnnW4vgCFaDjCFtGw3Kk", "time": 1688020100, "type": "withdrawal", "aclass": "currency", "asset": "XETH", "amount": "-1.2500000000", "fee": "0.0005000000", "ID": "L-WITHDRAWAL"},
+		{"refid": "FTQcuak-V6Za8qrWnhzTx67yYHz8Tg", "time": 1688014700, "type": "deposit", "aclass": "currency", "asset": "XXBT", "amount": "0.7800000000", "fee": "0", "ID": "L-DEPOSIT"}
+	]`)
+
+	if err := f.collectLedgerRecs(context.Background(), recs, map[string][]g.LedgerRec{}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := f.submitLedgerTransfers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(s.transfers) != 2 {
+		t.Fatalf("submitted %d transfers, want 2", len(s.transfers))
+	}
+
+	st := f.transferStatus["FTQcuak-YdFnnW4vgCFaDjCFtGw3Kk"]
+	if st.Method != "Ether" || st.Network != "Arbitrum One" || st.Key != "hardware wallet" || st.Info != "0x71C7656EC7ab88b098defB751B7401B5f6d8976F" {
+		t.Errorf("unexpected withdrawal status: %+v", st)
+	}
+
+	want := map[string]struct {
+		source, destination, comment string
+	}{
+		"L-WITHDRAWAL": {
+			source:      "Kraken",
+			destination: "0x71C7656EC7ab88b098defB751B7401B5f6d8976F",
+			comment:     "Ether via Arbitrum One, txid 0x0d6c1f6ef4ad4f1b9e8d6ef0b4b0d2c4c9a8f7e6d5c4b3a2918070605040302, address 0x71C7656EC7ab88b098defB751B7401B5f6d8976F, status Success",
+		},
+		"L-DEPOSIT": {
+			destination: "Kraken",
+			comment:     "Bitcoin, txid 6544b41b607d8b2512baf801755a3a9b8a1a7c8e05f6f7b5c4d3e2f1a0b9c8d7, address bc1qk4zz8dwq3qmndsmyqzzzcvwqq7cfwuzzsgv0sy, status Success",
+		},
+	}
+
+	for _, tr := range s.transfers {
+		w := want[tr.TxID]
+		if tr.Source != w.source || tr.Destination != w.destination || tr.Comment != w.comment {
+			t.Errorf("transfer %s = %q → %q %q, want %q → %q %q", tr.TxID, tr.Source, tr.Destination, tr.Comment, w.source, w.destination, w.comment)
+		}
+	}
+}
+
+func TestTransferComment(t *testing.T) {
+	tests := []struct {
+		st   krakenapi.TransferStatus
+		want string
+	}{
+		{krakenapi.TransferStatus{Method: "Bitcoin", Network: "Bitcoin", Status: "Success"}, "Bitcoin, status Success"},
+		{krakenapi.TransferStatus{Method: "Bitcoin", Status: "Failure", StatusProp: "canceled"}, "Bitcoin, status Failure (canceled)"},
+		{krakenapi.TransferStatus{TxID: "abc"}, "txid abc"},
+	}
+
+	for _, tt := range tests {
+		if got := transferComment(tt.st); got != tt.want {
+			t.Errorf("transferComment(%+v) = %q, want %q", tt.st, got, tt.want)
+		}
+	}
+}
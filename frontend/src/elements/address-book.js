@@ -0,0 +1,207 @@
+/**
+@license
+Copyright (c) 2024 trading_peter
+This program is available under Apache License Version 2.0
+*/
+
+import '@tp/tp-button/tp-button.js';
+import '@tp/tp-dialog/tp-dialog.js';
+import { LitElement, html, css } from 'lit';
+import { fetchMixin } from '@tp/helpers/fetch-mixin.js';
+import { DomQuery } from '@tp/helpers/dom-query.js';
+
+class AddressBook extends fetchMixin(DomQuery(LitElement)) {
+  static get styles() {
+    return [
+      css`
+        :host {
+          display: block;
+        }
+
+        tp-dialog {
+          --tp-dialog-width: 1000px;
+        }
+
+        h2 {
+          font-weight: normal;
+          font-size: 22px;
+          margin: 0 0 20px 0;
+        }
+
+        p {
+          color: var(--text-low);
+        }
+
+        p.error {
+          color: var(--red);
+        }
+
+        input[type="text"] {
+          box-sizing: border-box;
+          background: var(--input-bg);
+          border: var(--input-border);
+          outline: none;
+          border-radius: 2px;
+          color: var(--text);
+          font-size: 16px;
+          font-family: 'Source Sans Pro';
+          padding: 5px;
+          width: 100%;
+        }
+
+        input[type="text"]:focus {
+          border: solid 1px var(--hl1);
+        }
+
+        .table {
+          max-height: 50vh;
+          overflow: auto;
+        }
+
+        table {
+          width: 100%;
+          border-collapse: collapse;
+          font-size: 14px;
+        }
+
+        th {
+          text-align: left;
+          color: var(--text-low);
+          font-weight: normal;
+          position: sticky;
+          top: 0;
+          background: var(--card-box-background);
+        }
+
+        th, td {
+          padding: 4px 8px;
+        }
+
+        td.address {
+          word-break: break-all;
+        }
+
+        .buttons-justified {
+          margin-top: 30px;
+          display: flex;
+          justify-content: space-between;
+        }
+
+        .buttons-justified > div > * + * {
+          margin-left: 10px;
+        }
+      `
+    ];
+  }
+
+  render() {
+    const { account, rows, errorMessage } = this;
+
+    return html`
+      <tp-dialog id="dialog" showClose>
+        <h2>Withdrawal addresses of ${account?.label}</h2>
+        <p>Withdrawals to a labeled address are imported with the label as destination, e.g. the name of your hardware wallet or another exchange account in f-taxes.</p>
+
+        ${errorMessage ? html`<p class="error">${errorMessage}</p>` : null}
+
+        ${rows.length === 0 ? html`
+          <p>No addresses loaded yet. Click "Load from Kraken" to fetch the addresses saved in this account.</p>
+        ` : html`
+          <div class="table">
+            <table>
+              <thead>
+                <tr>
+                  <th>Name</th><th>Asset</th><th>Method</th><th>Address</th><th>Label in f-taxes</th>
+                </tr>
+              </thead>
+              <tbody>
+                ${rows.map((r, idx) => html`
+                  <tr>
+                    <td>${r.key}</td>
+                    <td>${r.asset}</td>
+                    <td>${r.method}</td>
+                    <td class="address">${r.address}</td>
+                    <td><input type="text" placeholder="e.g. Ledger Nano" .value=${r.label} @input=${e => this.updateLabel(idx, e.target.value)}></td>
+                  </tr>
+                `)}
+              </tbody>
+            </table>
+          </div>
+        `}
+
+        <div class="buttons-justified">
+          <tp-button id="syncBtn" @click=${this.sync}>Load from Kraken</tp-button>
+          <div>
+            <tp-button dialog-dismiss>Close</tp-button>
+            <tp-button id="saveBtn" @click=${this.save}>Save</tp-button>
+          </div>
+        </div>
+      </tp-dialog>
+    `;
+  }
+
+  static get properties() {
+    return {
+      account: { type: Object },
+      rows: { type: Array },
+      errorMessage: { type: String },
+    };
+  }
+
+  constructor() {
+    super();
+    this.rows = [];
+  }
+
+  async show(account) {
+    this.account = account;
+    this.errorMessage = '';
+    this.rows = [];
+    await this.load();
+    this.$.dialog.show();
+  }
+
+  async load() {
+    const resp = await this.post('/account/addresses', { id: this.account.id });
+
+    if (resp.result) {
+      this.rows = resp.data;
+    }
+  }
+
+  updateLabel(idx, label) {
+    this.rows = this.rows.map((r, i) => i === idx ? { ...r, label } : r);
+  }
+
+  async sync() {
+    this.$.syncBtn.showSpinner();
+    this.errorMessage = '';
+    const resp = await this.post('/account/addresses/sync', { id: this.account.id });
+
+    if (resp.result) {
+      this.$.syncBtn.showSuccess();
+      this.rows = resp.data;
+    } else {
+      this.$.syncBtn.showError();
+      this.errorMessage = resp.data || '';
+    }
+  }
+
+  async save() {
+    this.$.saveBtn.showSpinner();
+    const labels = this.rows
+      .filter(r => r.label.trim())
+      .map(r => ({ asset: r.asset, key: r.key, label: r.label.trim() }));
+
+    const resp = await this.post('/account/addresses/save', { id: this.account.id, labels });
+
+    if (resp.result) {
+      this.$.saveBtn.showSuccess();
+      this.rows = resp.data;
+    } else {
+      this.$.saveBtn.showError();
+    }
+  }
+}
+
+window.customElements.define('address-book', AddressBook);
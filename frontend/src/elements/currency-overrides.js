@@ -0,0 +1,154 @@
+/**
+@license
+Copyright (c) 2024 trading_peter
+This program is available under Apache License Version 2.0
+*/
+
+import '@tp/tp-button/tp-button.js';
+import './card-box.js';
+import { LitElement, html, css } from 'lit';
+import icons from '../icons';
+import { fetchMixin } from '@tp/helpers/fetch-mixin.js';
+import { DomQuery } from '@tp/helpers/dom-query.js';
+
+class CurrencyOverrides extends fetchMixin(DomQuery(LitElement)) {
+  static get styles() {
+    return [
+      css`
+        :host {
+          display: block;
+        }
+
+        card-box {
+          max-width: 800px;
+          margin: 20px auto 0 auto;
+        }
+
+        h2 {
+          font-weight: normal;
+          font-size: 22px;
+        }
+
+        p {
+          color: var(--text-low);
+        }
+
+        .row {
+          display: grid;
+          grid-template-columns: 1fr auto 1fr auto;
+          gap: 10px;
+          align-items: center;
+          margin-top: 10px;
+        }
+
+        input[type="text"] {
+          box-sizing: border-box;
+          background: var(--input-bg);
+          border: var(--input-border);
+          outline: none;
+          border-radius: 2px;
+          color: var(--text);
+          font-size: 18px;
+          font-family: 'Source Sans Pro';
+          padding: 5px;
+        }
+
+        input[type="text"]:focus {
+          border: solid 1px var(--hl1);
+        }
+
+        tp-button.only-icon tp-icon {
+          --tp-icon-height: 24px;
+          --tp-icon-width: 24px;
+          --tp-icon-color: var(--text);
+        }
+
+        .buttons-justified {
+          margin-top: 30px;
+          display: flex;
+          justify-content: space-between;
+        }
+      `
+    ];
+  }
+
+  render() {
+    return html`
+      <card-box>
+        <h2>Currency names</h2>
+        <p>Kraken's names are translated into the ones used by other exchanges (e.g. XXBT to BTC). Add a rule here if a currency still shows up under a different name than elsewhere in f-taxes.</p>
+
+        ${this.rows.map((r, idx) => html`
+          <div class="row">
+            <input type="text" placeholder="Kraken name, e.g. XDG" .value=${r.from} @input=${e => this.updateRow(idx, { from: e.target.value })}>
+            <span>→</span>
+            <input type="text" placeholder="Name in f-taxes, e.g. DOGE" .value=${r.to} @input=${e => this.updateRow(idx, { to: e.target.value })}>
+            <tp-button class="only-icon" extended @click=${() => this.removeRow(idx)}><tp-icon .icon=${icons.delete}></tp-icon></tp-button>
+          </div>
+        `)}
+
+        <div class="buttons-justified">
+          <tp-button @click=${this.addRow}>Add rule</tp-button>
+          <tp-button id="saveBtn" @click=${this.save}>Save</tp-button>
+        </div>
+      </card-box>
+    `;
+  }
+
+  static get properties() {
+    return {
+      rows: { type: Array },
+    };
+  }
+
+  constructor() {
+    super();
+    this.rows = [];
+  }
+
+  connectedCallback() {
+    super.connectedCallback();
+    this.load();
+  }
+
+  async load() {
+    const resp = await this.get('/currencies/overrides');
+
+    if (resp.result) {
+      this.rows = Object.keys(resp.data).sort().map(from => ({ from, to: resp.data[from] }));
+    }
+  }
+
+  addRow() {
+    this.rows = [...this.rows, { from: '', to: '' }];
+  }
+
+  updateRow(idx, change) {
+    this.rows = this.rows.map((r, i) => i === idx ? { ...r, ...change } : r);
+  }
+
+  removeRow(idx) {
+    this.rows = this.rows.filter((r, i) => i !== idx);
+  }
+
+  async save() {
+    this.$.saveBtn.showSpinner();
+    const overrides = {};
+    this.rows.forEach(r => {
+      if (r.from.trim() && r.to.trim()) {
+        overrides[r.from.trim()] = r.to.trim();
+      }
+    });
+
+    const resp = await this.post('/currencies/overrides/save', { overrides });
+
+    if (resp.result) {
+      this.$.saveBtn.showSuccess();
+      this.load();
+    } else {
+      this.$.saveBtn.showError();
+    }
+  }
+}
+
+window.customElements.define('currency-overrides', CurrencyOverrides);
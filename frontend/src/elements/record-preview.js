@@ -0,0 +1,269 @@
+/**
+@license
+Copyright (c) 2024 trading_peter
+This program is available under Apache License Version 2.0
+*/
+
+import '@tp/tp-button/tp-button.js';
+import '@tp/tp-dialog/tp-dialog.js';
+import { LitElement, html, css } from 'lit';
+import { formatTs } from '../helpers/time.js';
+import { fetchMixin } from '@tp/helpers/fetch-mixin.js';
+import { DomQuery } from '@tp/helpers/dom-query.js';
+
+class RecordPreview extends fetchMixin(DomQuery(LitElement)) {
+  static get styles() {
+    return [
+      css`
+        :host {
+          display: block;
+        }
+
+        tp-dialog {
+          --tp-dialog-width: 1100px;
+        }
+
+        h2 {
+          font-weight: normal;
+          font-size: 22px;
+          margin: 0 0 20px 0;
+        }
+
+        .counts {
+          display: flex;
+          flex-wrap: wrap;
+          gap: 10px;
+          margin-bottom: 20px;
+        }
+
+        .counts > div {
+          background: var(--bg0);
+          border-radius: 4px;
+          padding: 5px 10px;
+        }
+
+        .counts label {
+          color: var(--text-low);
+          margin-right: 5px;
+        }
+
+        .counts .warn {
+          color: var(--red);
+        }
+
+        .filters {
+          display: flex;
+          gap: 10px;
+          align-items: center;
+          margin-bottom: 10px;
+        }
+
+        select,
+        input[type="text"] {
+          box-sizing: border-box;
+          background: var(--input-bg);
+          border: var(--input-border);
+          outline: none;
+          border-radius: 2px;
+          color: var(--text);
+          font-size: 16px;
+          font-family: 'Source Sans Pro';
+          padding: 5px;
+        }
+
+        .table {
+          max-height: 50vh;
+          overflow: auto;
+        }
+
+        table {
+          width: 100%;
+          border-collapse: collapse;
+          font-size: 14px;
+        }
+
+        th {
+          text-align: left;
+          color: var(--text-low);
+          font-weight: normal;
+          position: sticky;
+          top: 0;
+          background: var(--card-box-background);
+        }
+
+        th, td {
+          padding: 4px 8px;
+          white-space: nowrap;
+        }
+
+        tr.warn td {
+          color: var(--red);
+        }
+
+        td.warnings {
+          white-space: normal;
+        }
+
+        .more {
+          text-align: center;
+          margin-top: 10px;
+        }
+
+        .buttons-justified {
+          margin-top: 30px;
+          display: flex;
+          justify-content: space-between;
+        }
+
+        .buttons-justified > div > * + * {
+          margin-left: 10px;
+        }
+      `
+    ];
+  }
+
+  render() {
+    const { page, filter } = this;
+    const batch = page?.batch || {};
+    const counts = page?.counts || {};
+
+    return html`
+      <tp-dialog id="dialog" showClose>
+        <h2>Preview of ${batch.accountLabel} (${batch.source === 'csv' ? 'CSV import' : 'fetch'})</h2>
+
+        <div class="counts">
+          <div><label>Records:</label>${batch.records || 0}</div>
+          ${Object.keys(counts).sort().map(k => html`<div><label>${k}:</label>${counts[k]}</div>`)}
+          <div class=${page?.warnings ? 'warn' : ''}><label>Warnings:</label>${page?.warnings || 0}</div>
+        </div>
+
+        <div class="filters">
+          <select .value=${filter.kind} @change=${e => this.setFilter({ kind: e.target.value })}>
+            <option value="">Trades & transfers</option>
+            <option value="trade">Trades</option>
+            <option value="transfer">Transfers</option>
+          </select>
+          <select .value=${filter.action} @change=${e => this.setFilter({ action: e.target.value })}>
+            <option value="">All actions</option>
+            ${['BUY', 'SELL', 'DEPOSIT', 'WITHDRAWAL'].map(a => html`<option value=${a}>${a}</option>`)}
+          </select>
+          <input type="text" placeholder="Asset" .value=${filter.asset} @change=${e => this.setFilter({ asset: e.target.value })}>
+          <input type="text" placeholder="Search" .value=${filter.search} @change=${e => this.setFilter({ search: e.target.value })}>
+          <label><input type="checkbox" .checked=${filter.warningsOnly} @change=${e => this.setFilter({ warningsOnly: e.target.checked })}> Only with warnings</label>
+        </div>
+
+        <div class="table">
+          <table>
+            <thead>
+              <tr>
+                <th>Time</th><th>Type</th><th>Action</th><th>Asset</th><th>Amount</th><th>Price</th><th>Fee</th><th>Tx ID</th><th>Warnings</th>
+              </tr>
+            </thead>
+            <tbody>
+              ${this.rows.map(r => html`
+                <tr class=${r.warnings?.length ? 'warn' : ''}>
+                  <td>${formatTs(r.ts, this.dateTimeFormat)}</td>
+                  <td>${r.kind}</td>
+                  <td>${r.action}</td>
+                  <td>${r.quote ? `${r.asset}/${r.quote}` : r.asset}</td>
+                  <td>${r.amount}</td>
+                  <td>${r.price || ''}</td>
+                  <td>${r.fee} ${r.feeCurrency}${r.quoteFee && r.quoteFee !== '0' ? ` + ${r.quoteFee} ${r.quote}` : ''}</td>
+                  <td>${r.txId}</td>
+                  <td class="warnings">${(r.warnings || []).join(' ')}</td>
+                </tr>
+              `)}
+            </tbody>
+          </table>
+        </div>
+
+        ${this.rows.length < (page?.total || 0) ? html`
+          <div class="more"><tp-button @click=${this.loadMore}>Show more (${page.total - this.rows.length} left)</tp-button></div>
+        ` : null}
+
+        <div class="buttons-justified">
+          <tp-button dialog-dismiss>Close</tp-button>
+          <div>
+            <tp-button id="discardBtn" class="danger" @click=${this.discard}>Discard</tp-button>
+            <tp-button id="commitBtn" ?disabled=${!batch.complete} @click=${this.commit}>Submit ${batch.records || 0} records</tp-button>
+          </div>
+        </div>
+      </tp-dialog>
+    `;
+  }
+
+  static get properties() {
+    return {
+      previewId: { type: String },
+      page: { type: Object },
+      rows: { type: Array },
+      filter: { type: Object },
+      dateTimeFormat: { type: String },
+    };
+  }
+
+  constructor() {
+    super();
+    this.rows = [];
+    this.filter = this.emptyFilter();
+  }
+
+  emptyFilter() {
+    return { kind: '', action: '', asset: '', search: '', warningsOnly: false };
+  }
+
+  async show(previewId) {
+    this.previewId = previewId;
+    this.filter = this.emptyFilter();
+    await this.load();
+    this.$.dialog.show();
+  }
+
+  setFilter(change) {
+    this.filter = { ...this.filter, ...change };
+    this.load();
+  }
+
+  async load() {
+    const resp = await this.post('/preview/records', { id: this.previewId, ...this.filter, offset: 0 });
+
+    if (resp.result) {
+      this.page = resp.data;
+      this.rows = resp.data.rows;
+    }
+  }
+
+  async loadMore() {
+    const resp = await this.post('/preview/records', { id: this.previewId, ...this.filter, offset: this.rows.length });
+
+    if (resp.result) {
+      this.page = resp.data;
+      this.rows = [...this.rows, ...resp.data.rows];
+    }
+  }
+
+  async commit() {
+    this.$.commitBtn.showSpinner();
+    const resp = await this.post('/preview/commit', { id: this.previewId });
+
+    if (resp.result) {
+      this.$.commitBtn.showSuccess();
+      this.close();
+    } else {
+      this.$.commitBtn.showError();
+      this.dispatchEvent(new CustomEvent('preview-error', { detail: resp.data, bubbles: true, composed: true }));
+    }
+  }
+
+  async discard() {
+    await this.post('/preview/discard', { id: this.previewId });
+    this.close();
+  }
+
+  close() {
+    this.$.dialog.close();
+    this.dispatchEvent(new CustomEvent('preview-closed', { bubbles: true, composed: true }));
+  }
+}
+
+window.customElements.define('record-preview', RecordPreview);
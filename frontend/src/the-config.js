@@ -9,6 +9,9 @@ import '@tp/tp-input/tp-input.js';
 import '@tp/tp-button/tp-button.js';
 import '@tp/tp-dialog/tp-dialog.js';
 import './elements/card-box.js';
+import './elements/record-preview.js';
+import './elements/currency-overrides.js';
+import './elements/address-book.js';
 import { LitElement, html, css } from 'lit';
 import icons from './icons';
 import { formatTs, isZero } from './helpers/time.js';
@@ -171,6 +174,20 @@ class TheConfig extends fetchMixin(DomQuery(LitElement)) {
           height: 80px;
         }
 
+        select {
+          width: 100%;
+          box-sizing: border-box;
+          background: var(--input-bg);
+          border: var(--input-border);
+          outline: none;
+          border-radius: 2px;
+          color: var(--text);
+          font-size: 18px;
+          font-family: 'Source Sans Pro';
+          padding: 5px;
+          margin-bottom: 10px;
+        }
+
         textarea:focus {
           border: solid 1px var(--hl1);
         }
@@ -185,10 +202,49 @@ class TheConfig extends fetchMixin(DomQuery(LitElement)) {
           justify-content: space-between;
         }
 
+        .permission {
+          margin-bottom: 10px;
+        }
+
+        .permission.granted {
+          color: var(--hl1);
+        }
+
+        .permission.missing {
+          color: var(--red);
+        }
+
+        .permission .hint {
+          color: var(--text-low);
+          font-size: 14px;
+          margin-left: 20px;
+        }
+
         tp-dialog h2 {
           margin: 0 0 20px 0;
         }
 
+        .report-list {
+          max-height: 300px;
+          overflow: auto;
+          font-size: 14px;
+          color: var(--text-low);
+        }
+
+        .previews-title {
+          margin-top: 30px;
+        }
+
+        .pending-preview {
+          display: flex;
+          justify-content: space-between;
+          align-items: center;
+          margin-top: 10px;
+          background: var(--bg0);
+          padding: 10px;
+          border-radius: 4px;
+        }
+
         tp-button.only-icon tp-icon {
           margin: 0;
           --tp-icon-height: 24px;
@@ -199,7 +255,7 @@ class TheConfig extends fetchMixin(DomQuery(LitElement)) {
   }
 
   render() {
-    const { accounts, settings } = this;
+    const { accounts, settings, probe, editing, previews, report } = this;
 
     return html`
       <card-box>
@@ -229,6 +285,22 @@ class TheConfig extends fetchMixin(DomQuery(LitElement)) {
                   <tp-button id=${'fetch_' + con._id} class="only-icon" extended @click=${e => this.fetchData(e, con)}><tp-icon .icon=${icons.refresh}></tp-icon></tp-button>
                 </tp-tooltip-wrapper>
 
+                <tp-tooltip-wrapper text="Fetch newest data without submitting it, to review it first" tooltipValign="top">
+                  <tp-button class="only-icon" extended @click=${e => this.previewData(e, con)}><tp-icon .icon=${icons.preview}></tp-icon></tp-button>
+                </tp-tooltip-wrapper>
+
+                <tp-tooltip-wrapper text="Check f-taxes for missing records and submit them again" tooltipValign="top">
+                  <tp-button class="only-icon" extended @click=${e => this.reconcile(e, con)}><tp-icon .icon=${icons.sync}></tp-icon></tp-button>
+                </tp-tooltip-wrapper>
+
+                <tp-tooltip-wrapper text="Label the withdrawal addresses saved in this account" tooltipValign="top">
+                  <tp-button class="only-icon" extended @click=${() => this.$.addressBook.show(con)}><tp-icon .icon=${icons.addresses}></tp-icon></tp-button>
+                </tp-tooltip-wrapper>
+
+                <tp-tooltip-wrapper text="Edit label, notes or API key" tooltipValign="top">
+                  <tp-button class="only-icon" extended @click=${() => this.startEditAccount(con)}><tp-icon .icon=${icons.edit}></tp-icon></tp-button>
+                </tp-tooltip-wrapper>
+
                 <tp-tooltip-wrapper text="Remove source and it's associated data" tooltipValign="top">
                   <tp-button class="only-icon" extended @click=${() => this.confirmRemoveAccount(con)}><tp-icon .icon=${icons.delete}></tp-icon></tp-button>
                 </tp-tooltip-wrapper>
@@ -236,38 +308,113 @@ class TheConfig extends fetchMixin(DomQuery(LitElement)) {
             </div>
           `)}
         </div>
+
+        ${previews.length > 0 ? html`
+          <h3 class="previews-title">Previews waiting for review</h3>
+          ${previews.map(p => html`
+            <div class="pending-preview">
+              <div>${p.accountLabel} · ${p.source === 'csv' ? 'CSV import' : 'Fetch'} · ${formatTs(p.created, settings?.dateTimeFormat)} · ${p.records} records${p.complete ? '' : ' (incomplete)'}</div>
+              <tp-button @click=${() => this.$.preview.show(p.id)}>Review</tp-button>
+            </div>
+          `)}
+        ` : null}
       </card-box>
 
+      <currency-overrides></currency-overrides>
+
+      <address-book id="addressBook"></address-book>
+
+      <record-preview id="preview" .dateTimeFormat=${settings?.dateTimeFormat} @preview-closed=${this.fetchPreviews} @preview-error=${this.showPreviewError}></record-preview>
+
       <tp-dialog id="addAccountDialog" showClose>
-        <h2>Add Kraken account</h2>
+        <h2>${editing ? 'Edit' : 'Add'} Kraken account</h2>
         <tp-form @submit=${this.addAccount}>
           <form>
             <label>Label</label>
             <tp-input name="label" required errorMessage="Required">
-              <input type="text">
+              <input type="text" .value=${editing?.label || ''}>
             </tp-input>
 
             <label>API Key</label>
             <tp-input name="key" required errorMessage="Required">
-              <input type="text">
+              <input type="text" .value=${editing?.key || ''}>
             </tp-input>
 
             <label>API Secret</label>
-            <tp-input name="secret" required errorMessage="Required">
-              <input type="password">
+            <tp-input name="secret" ?required=${!editing} errorMessage="Required">
+              <input type="password" placeholder=${editing ? 'Unchanged' : ''} .value=${''}>
+            </tp-input>
+
+            <label>2FA Password</label>
+            <select name="otpType" .value=${editing?.otpType || ''}>
+              <option value="">None</option>
+              <option value="static">Static password</option>
+              <option value="totp">Authenticator app (TOTP secret)</option>
+            </select>
+            <tp-input name="otp">
+              <input type="password" placeholder=${editing?.otpType ? 'Unchanged' : ''} .value=${''}>
             </tp-input>
 
             <label>Notes</label>
-            <textarea name="notes"></textarea>
+            <textarea name="notes" .value=${editing?.notes || ''}></textarea>
 
             <div class="buttons-justified">
               <tp-button dialog-dismiss>Cancel</tp-button>
-              <tp-button id="addAccountBtn" submit>Add</tp-button>
+              <tp-button id="addAccountBtn" submit>${editing ? 'Save' : 'Add'}</tp-button>
             </div>
           </form>
         </tp-form>
       </tp-dialog>
 
+      <tp-dialog id="probeDialog" showClose>
+        <h2>API key check</h2>
+        ${probe.valid ? html`
+          <p>The key is valid, but some permissions the plugin needs are missing:</p>
+          <div class="permissions">
+            ${(probe.permissions || []).map(p => html`
+              <div class="permission ${p.granted ? 'granted' : 'missing'}">
+                <div>${p.granted ? '✓' : '✗'} ${p.permission}${p.required ? '' : ' (optional)'}</div>
+                ${p.message ? html`<div class="hint">${p.message}</div>` : null}
+              </div>
+            `)}
+          </div>
+        ` : html`
+          <p>${probe.message}</p>
+        `}
+        <div class="buttons-justified">
+          <tp-button dialog-dismiss>Cancel</tp-button>
+          ${probe.valid ? html`<tp-button class="danger" @click=${() => this.forceAddAccount()}>Save anyway</tp-button>` : null}
+        </div>
+      </tp-dialog>
+
+      <tp-dialog id="reconcileDialog" showClose>
+        <h2>Sync check of ${report.account}</h2>
+        <p>${report.local} records known to Kraken, ${report.core} in f-taxes.</p>
+        ${report.missing?.length ? html`
+          <p>${report.missing.length} records were missing in f-taxes, ${report.resubmitted} of them have been submitted again.</p>
+        ` : null}
+        ${report.extra?.length ? html`
+          <p>f-taxes holds ${report.extra.length} records that Kraken doesn't know about. They may have been added or changed by hand:</p>
+          <div class="report-list">
+            ${report.extra.map(e => html`<div>${formatTs(e.ts, settings?.dateTimeFormat)} · ${e.kind} ${e.txId} · ${e.action} ${e.amount} ${e.asset}</div>`)}
+          </div>
+        ` : null}
+        ${!report.missing?.length && !report.extra?.length ? html`<p>Everything is in sync.</p>` : null}
+        <div class="buttons-justified">
+          <div></div>
+          <tp-button dialog-dismiss>Close</tp-button>
+        </div>
+      </tp-dialog>
+
+      <tp-dialog id="errorDialog" showClose>
+        <h2>Something went wrong</h2>
+        <p>${this.errorMessage}</p>
+        <div class="buttons-justified">
+          <div></div>
+          <tp-button dialog-dismiss>Close</tp-button>
+        </div>
+      </tp-dialog>
+
       <tp-dialog id="removeAccountDialog" showClose>
         <h2>Confirm removal</h2>
         <p>Do you want to remove the Kraken account "${this.selAccount.label}"?<br>This will also delete all associated data like trades, transfers, etc.</p>
@@ -286,6 +433,11 @@ class TheConfig extends fetchMixin(DomQuery(LitElement)) {
       accounts: { type: Array },
       settings: { type: Object },
       selAccount: { type: Object },
+      errorMessage: { type: String },
+      probe: { type: Object },
+      editing: { type: Object },
+      previews: { type: Array },
+      report: { type: Object },
     };
   }
 
@@ -293,20 +445,37 @@ class TheConfig extends fetchMixin(DomQuery(LitElement)) {
     super();
     this.accounts = [];
     this.selAccount = {};
+    this.errorMessage = '';
+    this.probe = {};
+    this.editing = null;
+    this.previews = [];
+    this.report = {};
   }
 
   connectedCallback() {
     super.connectedCallback();
     this.fetchAccounts();
+    this.fetchPreviews();
   }
 
   startAddAccount() {
+    this.editing = null;
+    this.$.addAccountDialog.show();
+  }
+
+  startEditAccount(account) {
+    this.editing = account;
     this.$.addAccountDialog.show();
   }
 
+  get saveUrl() {
+    return this.editing ? '/account/update' : '/account/add';
+  }
+
   async addAccount(e) {
     this.$.addAccountBtn.showSpinner();
-    const resp = await this.post('/account/add', e.detail);
+    this.pendingAccount = this.editing ? { ...e.detail, id: this.editing.id, version: this.editing.version } : e.detail;
+    const resp = await this.post(this.saveUrl, this.pendingAccount);
     
     if (resp.result) {
       this.$.addAccountBtn.showSuccess();
@@ -314,6 +483,24 @@ class TheConfig extends fetchMixin(DomQuery(LitElement)) {
       this.fetchAccounts();
     } else {
       this.$.addAccountBtn.showError();
+
+      if (typeof resp.data === 'string' && resp.data !== '') {
+        this.errorMessage = resp.data;
+        this.$.errorDialog.show();
+      } else if (resp.data) {
+        this.probe = resp.data;
+        this.$.probeDialog.show();
+      }
+    }
+  }
+
+  async forceAddAccount() {
+    const resp = await this.post(this.saveUrl, { ...this.pendingAccount, force: true });
+
+    if (resp.result) {
+      this.$.probeDialog.close();
+      this.$.addAccountDialog.close();
+      this.fetchAccounts();
     }
   }
 
@@ -329,8 +516,68 @@ class TheConfig extends fetchMixin(DomQuery(LitElement)) {
     const btn = e.target;
     btn.showSpinner();
     const resp = await this.post('/account/fetch/one', { id: account.id });
-    if (!resp.result) {
+    if (resp.result) {
+      btn.showSuccess();
+      this.fetchAccounts();
+    } else {
       btn.showError();
+
+      if (resp.data) {
+        this.errorMessage = resp.data;
+        this.$.errorDialog.show();
+      }
+    }
+  }
+
+  async fetchPreviews() {
+    const resp = await this.get('/preview/list');
+
+    if (resp.result) {
+      this.previews = resp.data;
+    }
+    this.fetchAccounts();
+  }
+
+  async previewData(e, account) {
+    const btn = e.target;
+    btn.showSpinner();
+    const resp = await this.post('/preview/fetch', { id: account.id });
+    if (resp.result) {
+      btn.showSuccess();
+      this.fetchPreviews();
+      this.$.preview.show(resp.data.id);
+    } else {
+      btn.showError();
+
+      if (resp.data) {
+        this.errorMessage = resp.data;
+        this.$.errorDialog.show();
+      }
+    }
+  }
+
+  async reconcile(e, account) {
+    const btn = e.target;
+    btn.showSpinner();
+    const resp = await this.post('/account/reconcile', { id: account.id });
+    if (resp.result) {
+      btn.showSuccess();
+      this.report = resp.data;
+      this.$.reconcileDialog.show();
+    } else {
+      btn.showError();
+
+      if (resp.data) {
+        this.errorMessage = resp.data;
+        this.$.errorDialog.show();
+      }
+    }
+  }
+
+  showPreviewError(e) {
+    if (e.detail) {
+      this.errorMessage = e.detail;
+      this.$.errorDialog.show();
     }
   }
 
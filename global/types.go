@@ -13,8 +13,28 @@ type Account struct {
 	ApiKey    string `mapstructure:"key" json:"key"`
 	ApiSecret string `mapstructure:"secret" json:"secret"`
 
+	// Optional two-factor password of the API key. OtpType is either empty (no password),
+	// "static" for a fixed password or "totp" if Otp holds the secret of an authenticator app.
+	OtpType string `mapstructure:"otpType" json:"otpType"`
+	Otp     string `mapstructure:"otp" json:"otp"`
+
 	// Timestamp of the last time the plugin fetched trades from the source.
 	LastFetched string `mapstructure:"lastFetched" json:"lastFetched"`
+
+	// Incremented on every write. Updates must send the version they are based on.
+	Version int `mapstructure:"version" json:"version"`
+}
+
+// Masked returns a copy of the account that is safe to hand out to the web ui.
+// Only the first characters of the key are kept so that the user can tell keys apart.
+func (a Account) Masked() Account {
+	if len(a.ApiKey) > 6 {
+		a.ApiKey = a.ApiKey[:6]
+	}
+
+	a.ApiSecret = ""
+	a.Otp = ""
+	return a
 }
 
 type LedgerRecList []LedgerRec
@@ -40,6 +60,7 @@ type LedgerInfoDoc struct {
 	RefID   string  `json:"refid"`
 	Time    float64 `json:"time"`
 	Type    string  `json:"type"`
+	Subtype string  `json:"subtype"`
 	Aclass  string  `json:"aclass"`
 	Asset   string  `json:"asset"`
 	Amount  string  `json:"amount"`
@@ -2,6 +2,8 @@ package grpc_client
 
 import (
 	"context"
+	"errors"
+	"io"
 	"time"
 
 	"github.com/f-taxes/kraken_import/global"
@@ -15,6 +17,9 @@ import (
 
 var GrpcClient *FTaxesClient
 
+// ErrOffline is returned when the plugin runs without a connection to the f-taxes core.
+var ErrOffline = errors.New("not connected to f-taxes")
+
 type FTaxesClient struct {
 	conStr     string
 	Connection *grpc.ClientConn
@@ -53,7 +58,17 @@ func (c *FTaxesClient) Connect(ctx context.Context) error {
 	return nil
 }
 
+// Offline returns true if the plugin runs headless, without a connection to the f-taxes core.
+// Progress and log messages are written to the local log instead.
+func (c *FTaxesClient) Offline() bool {
+	return c == nil || c.GrpcClient == nil
+}
+
 func (c *FTaxesClient) SubmitTrade(ctx context.Context, t *proto.Trade) error {
+	if c.Offline() {
+		return ErrOffline
+	}
+
 	t.Plugin = global.Plugin.ID
 	t.PluginVersion = global.Plugin.Version
 	t.Created = timestamppb.Now()
@@ -62,6 +77,10 @@ func (c *FTaxesClient) SubmitTrade(ctx context.Context, t *proto.Trade) error {
 }
 
 func (c *FTaxesClient) SubmitTransfer(ctx context.Context, transfer *proto.Transfer) error {
+	if c.Offline() {
+		return ErrOffline
+	}
+
 	transfer.Plugin = global.Plugin.ID
 	transfer.PluginVersion = global.Plugin.Version
 	transfer.Created = timestamppb.Now()
@@ -70,17 +89,32 @@ func (c *FTaxesClient) SubmitTransfer(ctx context.Context, transfer *proto.Trans
 }
 
 func (c *FTaxesClient) SubmitGenericFee(ctx context.Context, gf *proto.SrcGenericFee) error {
+	if c.Offline() {
+		return ErrOffline
+	}
+
 	_, err := c.GrpcClient.SubmitGenericFee(ctx, gf)
 	return err
 }
 
 func (c *FTaxesClient) ShowJobProgress(ctx context.Context, job *proto.JobProgress) error {
+	if c.Offline() {
+		if job.Label != "" {
+			golog.Info(job.Label)
+		}
+		return nil
+	}
+
 	job.Plugin = global.Plugin.Label
 	_, err := c.GrpcClient.ShowJobProgress(ctx, job)
 	return err
 }
 
 func (c *FTaxesClient) GetSettings(ctx context.Context) (*proto.Settings, error) {
+	if c.Offline() {
+		return nil, ErrOffline
+	}
+
 	settings, err := c.GrpcClient.GetSettings(ctx, nil)
 	if err != nil {
 		return nil, err
@@ -89,11 +123,56 @@ func (c *FTaxesClient) GetSettings(ctx context.Context) (*proto.Settings, error)
 }
 
 func (c *FTaxesClient) AppLog(ctx context.Context, msg *proto.AppLogMsg, opts ...grpc.CallOption) error {
+	if c.Offline() {
+		switch msg.Level {
+		case proto.LogLevel_ERR:
+			golog.Error(msg.Message)
+		case proto.LogLevel_WARN:
+			golog.Warn(msg.Message)
+		default:
+			golog.Info(msg.Message)
+		}
+		return nil
+	}
+
 	_, err := c.GrpcClient.AppLog(ctx, msg)
 	return err
 }
 
+// StreamRecords calls fn for every record the core holds for this plugin within the time range of the job.
+func (c *FTaxesClient) StreamRecords(ctx context.Context, job *proto.StreamRecordsJob, fn func(rec *proto.Record) error) error {
+	if c.Offline() {
+		return ErrOffline
+	}
+
+	job.Plugin = global.Plugin.ID
+	job.PluginVersion = global.Plugin.Version
+
+	stream, err := c.GrpcClient.StreamRecords(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	for {
+		rec, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
 func (c *FTaxesClient) PluginHeartbeat(ctx context.Context) error {
+	if c.Offline() {
+		return ErrOffline
+	}
+
 	_, err := c.GrpcClient.PluginHeartbeat(ctx, &proto.PluginInfo{ID: global.Plugin.ID, Version: global.Plugin.Version, HasCtlServer: global.Plugin.Ctl.Address != ""})
 	return err
 }
@@ -0,0 +1,191 @@
+package krakenapi
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// Error classes for the Kraken error codes callers need to tell apart.
+// Use errors.Is to test an error returned by any KrakenAPI method against them.
+var (
+	ErrInvalidKey         = errors.New("invalid api key or secret")
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrInvalidNonce       = errors.New("invalid nonce")
+	ErrRateLimit          = errors.New("rate limit exceeded")
+	ErrServiceUnavailable = errors.New("service unavailable")
+	ErrTemporaryLockout   = errors.New("temporary lockout")
+)
+
+// errorClasses maps the error strings Kraken returns to one of the error classes above.
+var errorClasses = map[string]error{
+	"EAPI:Invalid key":           ErrInvalidKey,
+	"EAPI:Invalid signature":     ErrInvalidKey,
+	"EGeneral:Permission denied": ErrPermissionDenied,
+	"EAPI:Invalid nonce":         ErrInvalidNonce,
+	"EAPI:Rate limit exceeded":   ErrRateLimit,
+	"EOrder:Rate limit exceeded": ErrRateLimit,
+	"EGeneral:Too many requests": ErrRateLimit,
+	"EService:Unavailable":       ErrServiceUnavailable,
+	"EService:Busy":              ErrServiceUnavailable,
+	"EService:Deadline elapsed":  ErrServiceUnavailable,
+	"EGeneral:Internal error":    ErrServiceUnavailable,
+	"EGeneral:Temporary lockout": ErrTemporaryLockout,
+}
+
+// Permission names as they are labeled in the API key settings on kraken.com.
+const (
+	PermQueryFunds        = "Query Funds"
+	PermWithdrawFunds     = "Withdraw Funds"
+	PermQueryOpenOrders   = "Query Open Orders & Trades"
+	PermQueryClosedOrders = "Query Closed Orders & Trades"
+	PermQueryLedger       = "Query Ledger Entries"
+	PermExportData        = "Export Data"
+)
+
+// methodPermissions lists the key permission each private method requires. Methods that accept one of several
+// permissions list the one granting the least, e.g. WithdrawStatus works with "Withdraw Funds" or "Query Ledger Entries".
+var methodPermissions = map[string]string{
+	"Balance":           PermQueryFunds,
+	"TradeBalance":      PermQueryFunds,
+	"DepositMethods":    PermQueryFunds,
+	"DepositAddresses":  PermQueryFunds,
+	"DepositStatus":     PermQueryFunds,
+	"WithdrawInfo":      PermWithdrawFunds,
+	"WithdrawStatus":    PermQueryLedger,
+	"WithdrawAddresses": PermWithdrawFunds,
+	"OpenOrders":        PermQueryOpenOrders,
+	"OpenPositions":     PermQueryOpenOrders,
+	"ClosedOrders":      PermQueryClosedOrders,
+	"QueryOrders":       PermQueryClosedOrders,
+	"QueryTrades":       PermQueryClosedOrders,
+	"TradesHistory":     PermQueryClosedOrders,
+	"TradeVolume":       PermQueryClosedOrders,
+	"Ledgers":           PermQueryLedger,
+	"QueryLedgers":      PermQueryLedger,
+	"AddExport":         PermExportData,
+	"ExportStatus":      PermExportData,
+	"RetrieveExport":    PermExportData,
+	"RemoveExport":      PermExportData,
+}
+
+// RequiredPermission returns the API key permission needed to call the given private method.
+func RequiredPermission(method string) string {
+	return methodPermissions[method]
+}
+
+// APIError is returned when Kraken answers a request with a non-empty error list.
+type APIError struct {
+	Method string   // Name of the API method that failed, e.g. "Ledgers".
+	Codes  []string // Error strings as returned by Kraken, e.g. "EAPI:Invalid nonce".
+	class  error
+}
+
+func newAPIError(method string, codes []string) *APIError {
+	e := &APIError{Method: method, Codes: codes}
+
+	for _, c := range codes {
+		if class := errorClasses[c]; class != nil {
+			e.class = class
+			break
+		}
+	}
+
+	return e
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Could not execute request! #7 (%s: %s)", e.Method, strings.Join(e.Codes, ", "))
+}
+
+// Unwrap returns the error class (ErrInvalidKey, ErrRateLimit, ...) the error belongs to, if any.
+func (e *APIError) Unwrap() error {
+	return e.class
+}
+
+// IsTransient reports whether a request that failed with err is worth retrying.
+// Rate limits, an unavailable service, nonce collisions and network timeouts are transient.
+// A temporary lockout is not, as retrying only extends the lockout.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrRateLimit) || errors.Is(err, ErrServiceUnavailable) || errors.Is(err, ErrInvalidNonce) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryable reports whether a request of method that failed with err may be sent again. Methods that change the
+// account's state are only retried if Kraken rejected the request without executing it (rate limit, invalid nonce).
+// A Withdraw or AddOrder that timed out may have gone through and must not be sent twice.
+func retryable(method string, err error) bool {
+	if !isStringInSlice(method, stateChangingMethods) {
+		return IsTransient(err)
+	}
+
+	return errors.Is(err, ErrRateLimit) || errors.Is(err, ErrInvalidNonce)
+}
+
+// Guidance turns an error returned by the api into a message that tells the user what to do about it.
+func Guidance(err error) string {
+	var apiErr *APIError
+	method := ""
+	if errors.As(err, &apiErr) {
+		method = apiErr.Method
+	}
+
+	switch {
+	case errors.Is(err, ErrInvalidKey):
+		return "Kraken rejected the API key. Please check that key and secret were copied completely and that the key hasn't been deleted."
+	case errors.Is(err, ErrPermissionDenied):
+		if perm := RequiredPermission(method); perm != "" {
+			return fmt.Sprintf("The API key is missing a permission. Please enable \"%s\" for this key in the API settings on kraken.com.", perm)
+		}
+		return "The API key is missing a permission. Please check the key's permissions in the API settings on kraken.com."
+	case errors.Is(err, ErrInvalidNonce):
+		return "Kraken rejected the request nonce. Make sure the API key isn't used by another application at the same time or increase the nonce window of the key on kraken.com."
+	case errors.Is(err, ErrRateLimit):
+		return "Kraken's rate limit was exceeded. Please wait a few minutes and try again."
+	case errors.Is(err, ErrTemporaryLockout):
+		return "The API key is temporarily locked out by Kraken because of too many failed or excessive requests. Please wait about 15 minutes before trying again."
+	case errors.Is(err, ErrServiceUnavailable):
+		return "Kraken's API is currently unavailable. Please try again later."
+	}
+
+	return err.Error()
+}
+
+// RetryPolicy controls how often and how long transient failures are retried.
+type RetryPolicy struct {
+	MaxAttempts int           // Total number of attempts including the first one. Values below 2 disable retries.
+	BaseDelay   time.Duration // Upper bound of the delay before the first retry. Doubles with every attempt.
+	MaxDelay    time.Duration // Upper bound of any delay.
+}
+
+// DefaultRetryPolicy is used by clients created with New.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   2 * time.Second,
+	MaxDelay:    30 * time.Second,
+}
+
+// delay returns a random ("full jitter") backoff for the given retry attempt, starting at 1.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	if d <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(d)))
+}
@@ -1,6 +1,7 @@
 package krakenapi
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/sha512"
@@ -13,6 +14,7 @@ import (
 	"mime"
 	"net/http"
 	"net/url"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -65,11 +67,23 @@ var privateMethods = []string{
 	"TradeVolume",
 	"WalletTransfer",
 	"Withdraw",
+	"WithdrawAddresses",
 	"WithdrawCancel",
 	"WithdrawInfo",
 	"WithdrawStatus",
 }
 
+// Private methods that change the account's state. They aren't retried once they may have reached Kraken, see retryable.
+var stateChangingMethods = []string{
+	"AddExport",
+	"AddOrder",
+	"CancelOrder",
+	"RemoveExport",
+	"WalletTransfer",
+	"Withdraw",
+	"WithdrawCancel",
+}
+
 // These represent the minimum order sizes for the respective coins
 // Should be monitored through here: https://support.kraken.com/hc/en-us/articles/205893708-What-is-the-minimum-order-size-
 const (
@@ -97,17 +111,34 @@ type KrakenApi = KrakenAPI
 
 // KrakenAPI represents a Kraken API Client connection
 type KrakenAPI struct {
-	key    string
-	secret string
-	client *http.Client
+	key           string
+	secret        string
+	baseURL       string
+	client        *http.Client
+	timeout       time.Duration
+	retry         RetryPolicy
+	nonce         NonceSource
+	otp           OTPSource
+	requestHooks  []RequestHook
+	responseHooks []ResponseHook
 }
 
+// RequestHook is called right before a request is sent to Kraken.
+type RequestHook func(req *http.Request)
+
+// ResponseHook is called once a request has been completed. resp is nil if no response was received.
+// The response body has already been consumed when the hook is called and must not be read.
+type ResponseHook func(req *http.Request, resp *http.Response, elapsed time.Duration, err error)
+
 // New creates a new Kraken API client
 func New(key, secret string) *KrakenAPI {
 	krakenAPI := KrakenAPI{
-		key:    key,
-		secret: secret,
-		client: http.DefaultClient,
+		key:     key,
+		secret:  secret,
+		baseURL: APIURL,
+		client:  http.DefaultClient,
+		retry:   DefaultRetryPolicy,
+		nonce:   sharedNonce(key),
 	}
 	return &krakenAPI
 }
@@ -124,9 +155,62 @@ func (api *KrakenAPI) WithClient(httpClient *http.Client) *KrakenAPI {
 	return api
 }
 
+// WithBaseURL points the client to another api endpoint, e.g. a local stand-in server for tests
+func (api *KrakenAPI) WithBaseURL(baseURL string) *KrakenAPI {
+	api.baseURL = strings.TrimSuffix(baseURL, "/")
+	return api
+}
+
+// WithTimeout limits how long a single request (not including retries) may take. Zero disables the limit
+func (api *KrakenAPI) WithTimeout(timeout time.Duration) *KrakenAPI {
+	api.timeout = timeout
+	return api
+}
+
+// WithProxy routes all requests through the given http proxy
+func (api *KrakenAPI) WithProxy(proxyURL *url.URL) *KrakenAPI {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+
+	client := *api.client
+	client.Transport = transport
+	api.client = &client
+	return api
+}
+
+// WithRequestHook registers a hook that is called before every request
+func (api *KrakenAPI) WithRequestHook(hook RequestHook) *KrakenAPI {
+	api.requestHooks = append(api.requestHooks, hook)
+	return api
+}
+
+// WithResponseHook registers a hook that is called after every request
+func (api *KrakenAPI) WithResponseHook(hook ResponseHook) *KrakenAPI {
+	api.responseHooks = append(api.responseHooks, hook)
+	return api
+}
+
+// WithNonceSource replaces the in-memory nonce source, e.g. with one that persists the last nonce across restarts
+func (api *KrakenAPI) WithNonceSource(nonce NonceSource) *KrakenAPI {
+	api.nonce = nonce
+	return api
+}
+
+// WithOTP sets the two-factor password source for API keys that are protected by one
+func (api *KrakenAPI) WithOTP(otp OTPSource) *KrakenAPI {
+	api.otp = otp
+	return api
+}
+
+// WithRetryPolicy sets how transient failures (rate limits, service outages, ...) are retried
+func (api *KrakenAPI) WithRetryPolicy(policy RetryPolicy) *KrakenAPI {
+	api.retry = policy
+	return api
+}
+
 // Time returns the server's time
-func (api *KrakenAPI) Time() (*TimeResponse, error) {
-	resp, err := api.queryPublicGet("Time", nil, &TimeResponse{})
+func (api *KrakenAPI) Time(ctx context.Context) (*TimeResponse, error) {
+	resp, err := api.queryPublicGet(ctx, "Time", nil, &TimeResponse{})
 	if err != nil {
 		return nil, err
 	}
@@ -135,8 +219,8 @@ func (api *KrakenAPI) Time() (*TimeResponse, error) {
 }
 
 // Assets returns the servers available assets
-func (api *KrakenAPI) Assets() (*AssetsResponse, error) {
-	resp, err := api.queryPublicGet("Assets", nil, &AssetsResponse{})
+func (api *KrakenAPI) Assets(ctx context.Context) (*AssetsResponse, error) {
+	resp, err := api.queryPublicGet(ctx, "Assets", nil, &AssetsResponse{})
 	if err != nil {
 		return nil, err
 	}
@@ -145,8 +229,8 @@ func (api *KrakenAPI) Assets() (*AssetsResponse, error) {
 }
 
 // AssetPairs returns the servers available asset pairs
-func (api *KrakenAPI) AssetPairs() (*AssetPairsResponse, error) {
-	resp, err := api.queryPublicGet("AssetPairs", nil, &AssetPairsResponse{})
+func (api *KrakenAPI) AssetPairs(ctx context.Context) (*AssetPairsResponse, error) {
+	resp, err := api.queryPublicGet(ctx, "AssetPairs", nil, &AssetPairsResponse{})
 	if err != nil {
 		return nil, err
 	}
@@ -155,8 +239,8 @@ func (api *KrakenAPI) AssetPairs() (*AssetPairsResponse, error) {
 }
 
 // Ticker returns the ticker for given comma separated pairs
-func (api *KrakenAPI) Ticker(pairs ...string) (*TickerResponse, error) {
-	resp, err := api.queryPublicGet("Ticker", url.Values{
+func (api *KrakenAPI) Ticker(ctx context.Context, pairs ...string) (*TickerResponse, error) {
+	resp, err := api.queryPublicGet(ctx, "Ticker", url.Values{
 		"pair": {strings.Join(pairs, ",")},
 	}, &TickerResponse{})
 	if err != nil {
@@ -167,7 +251,7 @@ func (api *KrakenAPI) Ticker(pairs ...string) (*TickerResponse, error) {
 }
 
 // OHLCWithInterval returns a OHLCResponse struct based on the given pair
-func (api *KrakenAPI) OHLCWithInterval(pair string, interval string) (*OHLCResponse, error) {
+func (api *KrakenAPI) OHLCWithInterval(ctx context.Context, pair string, interval string) (*OHLCResponse, error) {
 	urlValue := url.Values{}
 	urlValue.Add("pair", pair)
 
@@ -184,7 +268,7 @@ func (api *KrakenAPI) OHLCWithInterval(pair string, interval string) (*OHLCRespo
 	}
 
 	// Returns a map[string]interface{} as an interface{}
-	interfaceResponse, err := api.queryPublicGet("OHLC", urlValue, nil)
+	interfaceResponse, err := api.queryPublicGet(ctx, "OHLC", urlValue, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -211,14 +295,14 @@ func (api *KrakenAPI) OHLCWithInterval(pair string, interval string) (*OHLCRespo
 }
 
 // OHLC returns a OHLCResponse struct based on the given pair
-func (api *KrakenAPI) OHLC(pair string) (*OHLCResponse, error) {
-	ret, err := api.OHLCWithInterval(pair, "1")
+func (api *KrakenAPI) OHLC(ctx context.Context, pair string) (*OHLCResponse, error) {
+	ret, err := api.OHLCWithInterval(ctx, pair, "1")
 
 	return ret, err
 }
 
 // TradesHistory returns the Trades History within a specified time frame (start to end).
-func (api *KrakenAPI) TradesHistory(start int64, end int64, args map[string]string) (*TradesHistoryResponse, error) {
+func (api *KrakenAPI) TradesHistory(ctx context.Context, start int64, end int64, args map[string]string) (*TradesHistoryResponse, error) {
 	params := url.Values{}
 	if start > 0 {
 		params.Add("start", strconv.FormatInt(start, 10))
@@ -239,7 +323,7 @@ func (api *KrakenAPI) TradesHistory(start int64, end int64, args map[string]stri
 		params.Add("ledgers", value)
 	}
 
-	resp, err := api.queryPrivate("TradesHistory", params, &TradesHistoryResponse{})
+	resp, err := api.queryPrivate(ctx, "TradesHistory", params, &TradesHistoryResponse{})
 
 	if err != nil {
 		return nil, err
@@ -249,12 +333,12 @@ func (api *KrakenAPI) TradesHistory(start int64, end int64, args map[string]stri
 }
 
 // Trades returns the recent trades for given pair
-func (api *KrakenAPI) Trades(pair string, since int64) (*TradesResponse, error) {
+func (api *KrakenAPI) Trades(ctx context.Context, pair string, since int64) (*TradesResponse, error) {
 	values := url.Values{"pair": {pair}}
 	if since > 0 {
 		values.Set("since", strconv.FormatInt(since, 10))
 	}
-	resp, err := api.queryPublicGet("Trades", values, nil)
+	resp, err := api.queryPublicGet(ctx, "Trades", values, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -301,8 +385,8 @@ func (api *KrakenAPI) Trades(pair string, since int64) (*TradesResponse, error)
 }
 
 // Balance returns all account asset balances
-func (api *KrakenAPI) Balance() (*BalanceResponse, error) {
-	resp, err := api.queryPrivate("Balance", url.Values{}, &BalanceResponse{})
+func (api *KrakenAPI) Balance(ctx context.Context) (*BalanceResponse, error) {
+	resp, err := api.queryPrivate(ctx, "Balance", url.Values{}, &BalanceResponse{})
 	if err != nil {
 		return nil, err
 	}
@@ -311,7 +395,7 @@ func (api *KrakenAPI) Balance() (*BalanceResponse, error) {
 }
 
 // TradeBalance returns trade balance info
-func (api *KrakenAPI) TradeBalance(args map[string]string) (*TradeBalanceResponse, error) {
+func (api *KrakenAPI) TradeBalance(ctx context.Context, args map[string]string) (*TradeBalanceResponse, error) {
 	params := url.Values{}
 	if value, ok := args["aclass"]; ok {
 		params.Add("aclass", value)
@@ -319,7 +403,7 @@ func (api *KrakenAPI) TradeBalance(args map[string]string) (*TradeBalanceRespons
 	if value, ok := args["asset"]; ok {
 		params.Add("asset", value)
 	}
-	resp, err := api.queryPrivate("TradeBalance", params, &TradeBalanceResponse{})
+	resp, err := api.queryPrivate(ctx, "TradeBalance", params, &TradeBalanceResponse{})
 	if err != nil {
 		return nil, err
 	}
@@ -328,7 +412,7 @@ func (api *KrakenAPI) TradeBalance(args map[string]string) (*TradeBalanceRespons
 }
 
 // TradeVolume returns trade volume info
-func (api *KrakenAPI) TradeVolume(args map[string]string) (*TradeVolumeResponse, error) {
+func (api *KrakenAPI) TradeVolume(ctx context.Context, args map[string]string) (*TradeVolumeResponse, error) {
 	params := url.Values{}
 	if value, ok := args["pair"]; ok {
 		params.Add("pair", value)
@@ -336,7 +420,7 @@ func (api *KrakenAPI) TradeVolume(args map[string]string) (*TradeVolumeResponse,
 	if value, ok := args["fee-info"]; ok {
 		params.Add("fee-info", value)
 	}
-	resp, err := api.queryPrivate("TradeVolume", params, &TradeVolumeResponse{})
+	resp, err := api.queryPrivate(ctx, "TradeVolume", params, &TradeVolumeResponse{})
 	if err != nil {
 		return nil, err
 	}
@@ -345,7 +429,7 @@ func (api *KrakenAPI) TradeVolume(args map[string]string) (*TradeVolumeResponse,
 }
 
 // OpenOrders returns all open orders
-func (api *KrakenAPI) OpenOrders(args map[string]string) (*OpenOrdersResponse, error) {
+func (api *KrakenAPI) OpenOrders(ctx context.Context, args map[string]string) (*OpenOrdersResponse, error) {
 	params := url.Values{}
 	if value, ok := args["trades"]; ok {
 		params.Add("trades", value)
@@ -354,7 +438,7 @@ func (api *KrakenAPI) OpenOrders(args map[string]string) (*OpenOrdersResponse, e
 		params.Add("userref", value)
 	}
 
-	resp, err := api.queryPrivate("OpenOrders", params, &OpenOrdersResponse{})
+	resp, err := api.queryPrivate(ctx, "OpenOrders", params, &OpenOrdersResponse{})
 
 	if err != nil {
 		return nil, err
@@ -364,7 +448,7 @@ func (api *KrakenAPI) OpenOrders(args map[string]string) (*OpenOrdersResponse, e
 }
 
 // ClosedOrders returns all closed orders
-func (api *KrakenAPI) ClosedOrders(args map[string]string) (*ClosedOrdersResponse, error) {
+func (api *KrakenAPI) ClosedOrders(ctx context.Context, args map[string]string) (*ClosedOrdersResponse, error) {
 	params := url.Values{}
 	if value, ok := args["trades"]; ok {
 		params.Add("trades", value)
@@ -384,7 +468,7 @@ func (api *KrakenAPI) ClosedOrders(args map[string]string) (*ClosedOrdersRespons
 	if value, ok := args["closetime"]; ok {
 		params.Add("closetime", value)
 	}
-	resp, err := api.queryPrivate("ClosedOrders", params, &ClosedOrdersResponse{})
+	resp, err := api.queryPrivate(ctx, "ClosedOrders", params, &ClosedOrdersResponse{})
 
 	if err != nil {
 		return nil, err
@@ -394,9 +478,9 @@ func (api *KrakenAPI) ClosedOrders(args map[string]string) (*ClosedOrdersRespons
 }
 
 // Depth returns the order book for given pair and orders count.
-func (api *KrakenAPI) Depth(pair string, count int) (*OrderBook, error) {
+func (api *KrakenAPI) Depth(ctx context.Context, pair string, count int) (*OrderBook, error) {
 	dr := DepthResponse{}
-	_, err := api.queryPublicGet("Depth", url.Values{
+	_, err := api.queryPublicGet(ctx, "Depth", url.Values{
 		"pair": {pair}, "count": {strconv.Itoa(count)},
 	}, &dr)
 
@@ -412,10 +496,10 @@ func (api *KrakenAPI) Depth(pair string, count int) (*OrderBook, error) {
 }
 
 // CancelOrder cancels order
-func (api *KrakenAPI) CancelOrder(txid string) (*CancelOrderResponse, error) {
+func (api *KrakenAPI) CancelOrder(ctx context.Context, txid string) (*CancelOrderResponse, error) {
 	params := url.Values{}
 	params.Add("txid", txid)
-	resp, err := api.queryPrivate("CancelOrder", params, &CancelOrderResponse{})
+	resp, err := api.queryPrivate(ctx, "CancelOrder", params, &CancelOrderResponse{})
 
 	if err != nil {
 		return nil, err
@@ -425,7 +509,7 @@ func (api *KrakenAPI) CancelOrder(txid string) (*CancelOrderResponse, error) {
 }
 
 // QueryOrders shows order
-func (api *KrakenAPI) QueryOrders(txids string, args map[string]string) (*QueryOrdersResponse, error) {
+func (api *KrakenAPI) QueryOrders(ctx context.Context, txids string, args map[string]string) (*QueryOrdersResponse, error) {
 	params := url.Values{"txid": {txids}}
 	if value, ok := args["trades"]; ok {
 		params.Add("trades", value)
@@ -433,7 +517,7 @@ func (api *KrakenAPI) QueryOrders(txids string, args map[string]string) (*QueryO
 	if value, ok := args["userref"]; ok {
 		params.Add("userref", value)
 	}
-	resp, err := api.queryPrivate("QueryOrders", params, &QueryOrdersResponse{})
+	resp, err := api.queryPrivate(ctx, "QueryOrders", params, &QueryOrdersResponse{})
 
 	if err != nil {
 		return nil, err
@@ -443,7 +527,7 @@ func (api *KrakenAPI) QueryOrders(txids string, args map[string]string) (*QueryO
 }
 
 // AddOrder adds new order
-func (api *KrakenAPI) AddOrder(pair string, direction string, orderType string, volume string, args map[string]string) (*AddOrderResponse, error) {
+func (api *KrakenAPI) AddOrder(ctx context.Context, pair string, direction string, orderType string, volume string, args map[string]string) (*AddOrderResponse, error) {
 	params := url.Values{
 		"pair":      {pair},
 		"type":      {direction},
@@ -487,7 +571,7 @@ func (api *KrakenAPI) AddOrder(pair string, direction string, orderType string,
 	if value, ok := args["userref"]; ok {
 		params.Add("userref", value)
 	}
-	resp, err := api.queryPrivate("AddOrder", params, &AddOrderResponse{})
+	resp, err := api.queryPrivate(ctx, "AddOrder", params, &AddOrderResponse{})
 
 	if err != nil {
 		return nil, err
@@ -497,7 +581,7 @@ func (api *KrakenAPI) AddOrder(pair string, direction string, orderType string,
 }
 
 // Ledgers returns ledgers informations
-func (api *KrakenAPI) Ledgers(args map[string]string) (*LedgersResponse, error) {
+func (api *KrakenAPI) Ledgers(ctx context.Context, args map[string]string) (*LedgersResponse, error) {
 	params := url.Values{}
 	if value, ok := args["aclass"]; ok {
 		params.Add("aclass", value)
@@ -517,7 +601,7 @@ func (api *KrakenAPI) Ledgers(args map[string]string) (*LedgersResponse, error)
 	if value, ok := args["ofs"]; ok {
 		params.Add("ofs", value)
 	}
-	resp, err := api.queryPrivate("Ledgers", params, &LedgersResponse{})
+	resp, err := api.queryPrivate(ctx, "Ledgers", params, &LedgersResponse{})
 	if err != nil {
 		return nil, err
 	}
@@ -526,8 +610,8 @@ func (api *KrakenAPI) Ledgers(args map[string]string) (*LedgersResponse, error)
 }
 
 // DepositAddresses returns deposit addresses
-func (api *KrakenAPI) DepositAddresses(asset string, method string) (*DepositAddressesResponse, error) {
-	resp, err := api.queryPrivate("DepositAddresses", url.Values{
+func (api *KrakenAPI) DepositAddresses(ctx context.Context, asset string, method string) (*DepositAddressesResponse, error) {
+	resp, err := api.queryPrivate(ctx, "DepositAddresses", url.Values{
 		"asset":  {asset},
 		"method": {method},
 	}, &DepositAddressesResponse{})
@@ -538,8 +622,8 @@ func (api *KrakenAPI) DepositAddresses(asset string, method string) (*DepositAdd
 }
 
 // Withdraw executes a withdrawal, returning a reference ID
-func (api *KrakenAPI) Withdraw(asset string, key string, amount *big.Float) (*WithdrawResponse, error) {
-	resp, err := api.queryPrivate("Withdraw", url.Values{
+func (api *KrakenAPI) Withdraw(ctx context.Context, asset string, key string, amount *big.Float) (*WithdrawResponse, error) {
+	resp, err := api.queryPrivate(ctx, "Withdraw", url.Values{
 		"asset":  {asset},
 		"key":    {key},
 		"amount": {amount.String()},
@@ -551,8 +635,8 @@ func (api *KrakenAPI) Withdraw(asset string, key string, amount *big.Float) (*Wi
 }
 
 // WithdrawInfo returns withdrawal information
-func (api *KrakenAPI) WithdrawInfo(asset string, key string, amount *big.Float) (*WithdrawInfoResponse, error) {
-	resp, err := api.queryPrivate("WithdrawInfo", url.Values{
+func (api *KrakenAPI) WithdrawInfo(ctx context.Context, asset string, key string, amount *big.Float) (*WithdrawInfoResponse, error) {
+	resp, err := api.queryPrivate(ctx, "WithdrawInfo", url.Values{
 		"asset":  {asset},
 		"key":    {key},
 		"amount": {amount.String()},
@@ -563,8 +647,51 @@ func (api *KrakenAPI) WithdrawInfo(asset string, key string, amount *big.Float)
 	return resp.(*WithdrawInfoResponse), nil
 }
 
+// DepositStatus returns the status of recent deposits
+func (api *KrakenAPI) DepositStatus(ctx context.Context, args map[string]string) (*DepositStatusResponse, error) {
+	resp, err := api.queryPrivate(ctx, "DepositStatus", transferStatusParams(args), &DepositStatusResponse{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*DepositStatusResponse), nil
+}
+
+// WithdrawStatus returns the status of recent withdrawals
+func (api *KrakenAPI) WithdrawStatus(ctx context.Context, args map[string]string) (*WithdrawStatusResponse, error) {
+	resp, err := api.queryPrivate(ctx, "WithdrawStatus", transferStatusParams(args), &WithdrawStatusResponse{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*WithdrawStatusResponse), nil
+}
+
+// WithdrawAddresses returns the withdrawal addresses saved in the account
+func (api *KrakenAPI) WithdrawAddresses(ctx context.Context, args map[string]string) (*WithdrawAddressesResponse, error) {
+	params := url.Values{}
+	for _, key := range []string{"aclass", "asset", "method", "key", "verified"} {
+		if value, ok := args[key]; ok {
+			params.Add(key, value)
+		}
+	}
+	resp, err := api.queryPrivate(ctx, "WithdrawAddresses", params, &WithdrawAddressesResponse{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*WithdrawAddressesResponse), nil
+}
+
+func transferStatusParams(args map[string]string) url.Values {
+	params := url.Values{}
+	for _, key := range []string{"aclass", "asset", "method", "start", "end"} {
+		if value, ok := args[key]; ok {
+			params.Add(key, value)
+		}
+	}
+	return params
+}
+
 // Query sends a query to Kraken api for given method and parameters
-func (api *KrakenAPI) Query(method string, data map[string]string) (interface{}, error) {
+func (api *KrakenAPI) Query(ctx context.Context, method string, data map[string]string) (interface{}, error) {
 	values := url.Values{}
 	for key, value := range data {
 		values.Set(key, value)
@@ -572,53 +699,98 @@ func (api *KrakenAPI) Query(method string, data map[string]string) (interface{},
 
 	// Check if method is public or private
 	if isStringInSlice(method, publicMethods) {
-		return api.queryPublicPost(method, values, nil)
+		return api.queryPublicPost(ctx, method, values, nil)
 	} else if isStringInSlice(method, privateMethods) {
-		return api.queryPrivate(method, values, nil)
+		return api.queryPrivate(ctx, method, values, nil)
 	}
 
 	return nil, fmt.Errorf("Method '%s' is not valid", method)
 }
 
+// QueryPublicInto sends a query to a public method and unmarshals the result into typ
+func (api *KrakenAPI) QueryPublicInto(ctx context.Context, method string, values url.Values, typ interface{}) error {
+	if !isStringInSlice(method, publicMethods) {
+		return fmt.Errorf("Method '%s' is not valid", method)
+	}
+
+	_, err := api.queryPublicGet(ctx, method, values, typ)
+	return err
+}
+
 // Execute a public method query
-func (api *KrakenAPI) queryPublicPost(method string, values url.Values, typ interface{}) (interface{}, error) {
-	url := fmt.Sprintf("%s/%s/public/%s", APIURL, APIVersion, method)
-	resp, err := api.doPost(url, values, nil, typ)
+func (api *KrakenAPI) queryPublicPost(ctx context.Context, method string, values url.Values, typ interface{}) (interface{}, error) {
+	url := fmt.Sprintf("%s/%s/public/%s", api.baseURL, APIVersion, method)
 
-	return resp, err
+	return api.withRetry(ctx, method, func() (interface{}, error) {
+		return api.doPost(ctx, url, values, nil, typ)
+	})
 }
 
-func (api *KrakenAPI) queryPublicGet(reqURL string, values url.Values, typ interface{}) (interface{}, error) {
-	url := fmt.Sprintf("%s/%s/public/%s", APIURL, APIVersion, reqURL)
-	return api.doGet(url, values, nil, typ)
+func (api *KrakenAPI) queryPublicGet(ctx context.Context, reqURL string, values url.Values, typ interface{}) (interface{}, error) {
+	url := fmt.Sprintf("%s/%s/public/%s", api.baseURL, APIVersion, reqURL)
+
+	return api.withRetry(ctx, reqURL, func() (interface{}, error) {
+		return api.doGet(ctx, url, values, nil, typ)
+	})
 }
 
 // queryPrivate executes a private method query
-func (api *KrakenAPI) queryPrivate(method string, values url.Values, typ interface{}) (interface{}, error) {
+func (api *KrakenAPI) queryPrivate(ctx context.Context, method string, values url.Values, typ interface{}) (interface{}, error) {
 	urlPath := fmt.Sprintf("/%s/private/%s", APIVersion, method)
-	reqURL := fmt.Sprintf("%s%s", APIURL, urlPath)
+	reqURL := fmt.Sprintf("%s%s", api.baseURL, urlPath)
 	secret, _ := base64.StdEncoding.DecodeString(api.secret)
-	values.Set("nonce", fmt.Sprintf("%d", time.Now().UnixNano()))
 
-	// Create signature
-	signature := createSignature(urlPath, values, secret)
+	// Every attempt needs a fresh nonce and therefore a fresh signature.
+	return api.withRetry(ctx, method, func() (interface{}, error) {
+		nonce, err := api.nonce.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Could not execute request! #0 (%w)", err)
+		}
+		values.Set("nonce", strconv.FormatUint(nonce, 10))
+
+		if api.otp != nil {
+			otp, err := api.otp()
+			if err != nil {
+				return nil, fmt.Errorf("Could not execute request! #0 (%w)", err)
+			}
+			values.Set("otp", otp)
+		}
 
-	// Add Key and signature to request headers
-	headers := map[string]string{
-		"API-Key":  api.key,
-		"API-Sign": signature,
-	}
+		// Create signature
+		signature := createSignature(urlPath, values, secret)
 
-	resp, err := api.doPost(reqURL, values, headers, typ)
+		// Add Key and signature to request headers
+		headers := map[string]string{
+			"API-Key":  api.key,
+			"API-Sign": signature,
+		}
+
+		return api.doPost(ctx, reqURL, values, headers, typ)
+	})
+}
+
+// withRetry executes the request of method built by do and repeats it as long as it fails with an error that is
+// retryable for the method and the retry policy allows for another attempt.
+func (api *KrakenAPI) withRetry(ctx context.Context, method string, do func() (interface{}, error)) (interface{}, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := do()
+		if err == nil || !retryable(method, err) || attempt >= api.retry.MaxAttempts || ctx.Err() != nil {
+			return resp, err
+		}
 
-	return resp, err
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(api.retry.delay(attempt)):
+		}
+	}
 }
 
-func (api *KrakenAPI) doGet(reqURL string, values url.Values, headers map[string]string, typ interface{}) (interface{}, error) {
+func (api *KrakenAPI) doGet(ctx context.Context, reqURL string, values url.Values, headers map[string]string, typ interface{}) (interface{}, error) {
 	encodedValues := values.Encode()
 	fullURL := reqURL + "?" + encodedValues
 
-	req, err := http.NewRequest("GET", fullURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fullURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Could not execute request! #1 (%s)", err.Error())
 	}
@@ -627,10 +799,10 @@ func (api *KrakenAPI) doGet(reqURL string, values url.Values, headers map[string
 }
 
 // doPost executes a HTTP Request to the Kraken API and returns the result
-func (api *KrakenAPI) doPost(reqURL string, values url.Values, headers map[string]string, typ interface{}) (interface{}, error) {
+func (api *KrakenAPI) doPost(ctx context.Context, reqURL string, values url.Values, headers map[string]string, typ interface{}) (interface{}, error) {
 
 	// Create request
-	req, err := http.NewRequest("POST", reqURL, strings.NewReader(values.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, strings.NewReader(values.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("Could not execute request! #1 (%s)", err.Error())
 	}
@@ -638,16 +810,34 @@ func (api *KrakenAPI) doPost(reqURL string, values url.Values, headers map[strin
 	return api.doAPIRequest(req, headers, typ)
 }
 
-func (api *KrakenAPI) doAPIRequest(req *http.Request, headers map[string]string, typ interface{}) (interface{}, error) {
+func (api *KrakenAPI) doAPIRequest(req *http.Request, headers map[string]string, typ interface{}) (result interface{}, err error) {
+	if api.timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), api.timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
 	req.Header.Add("User-Agent", APIUserAgent)
 	for key, value := range headers {
 		req.Header.Add(key, value)
 	}
 
+	for _, hook := range api.requestHooks {
+		hook(req)
+	}
+
 	// Execute request
+	started := time.Now()
 	resp, err := api.client.Do(req)
+
+	defer func() {
+		for _, hook := range api.responseHooks {
+			hook(req, resp, time.Since(started), err)
+		}
+	}()
+
 	if err != nil {
-		return nil, fmt.Errorf("Could not execute request! #2 (%s)", err.Error())
+		return nil, fmt.Errorf("Could not execute request! #2 (%w)", err)
 	}
 	defer resp.Body.Close()
 
@@ -659,6 +849,10 @@ func (api *KrakenAPI) doAPIRequest(req *http.Request, headers map[string]string,
 
 	// Check mime type of response
 	mimeType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if (err != nil || mimeType != "application/json") && resp.StatusCode >= http.StatusInternalServerError {
+		// Kraken's edge answers with html error pages when the api is down or overloaded.
+		return nil, fmt.Errorf("Could not execute request! #5 (status %d: %w)", resp.StatusCode, ErrServiceUnavailable)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Could not execute request #4! (%s)", err.Error())
 	}
@@ -682,7 +876,7 @@ func (api *KrakenAPI) doAPIRequest(req *http.Request, headers map[string]string,
 
 	// Check for Kraken API error
 	if len(jsonData.Error) > 0 {
-		return nil, fmt.Errorf("Could not execute request! #7 (%s)", jsonData.Error)
+		return nil, newAPIError(path.Base(req.URL.Path), jsonData.Error)
 	}
 
 	return jsonData.Result, nil
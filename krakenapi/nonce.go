@@ -0,0 +1,104 @@
+package krakenapi
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NonceSource hands out the nonces for the private requests of one API key.
+// Kraken rejects any nonce that isn't larger than the last one it has seen for the key.
+type NonceSource interface {
+	Next() (uint64, error)
+}
+
+// MonotonicNonce derives nonces from the current unix time in nanoseconds, but never returns a value
+// that isn't larger than the previous one, even if several goroutines share it or the clock steps backwards.
+// If a path is set, the highest nonce handed out is persisted there so that it survives restarts.
+type MonotonicNonce struct {
+	mu   sync.Mutex
+	last uint64
+	path string
+}
+
+// NewMonotonicNonce creates a nonce source that persists its high-water mark in the file at path.
+// Pass an empty path to keep the nonce in memory only.
+func NewMonotonicNonce(path string) (*MonotonicNonce, error) {
+	n := &MonotonicNonce{path: path}
+
+	if path == "" {
+		return n, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return n, nil
+		}
+		return nil, err
+	}
+
+	n.last, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// Next returns the next nonce.
+func (n *MonotonicNonce) Next() (uint64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	next := uint64(time.Now().UnixNano())
+	if next <= n.last {
+		next = n.last + 1
+	}
+
+	if n.path != "" {
+		if err := n.persist(next); err != nil {
+			return 0, err
+		}
+	}
+
+	n.last = next
+	return next, nil
+}
+
+func (n *MonotonicNonce) persist(v uint64) error {
+	err := os.MkdirAll(filepath.Dir(n.path), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp := n.path + ".tmp"
+	err = os.WriteFile(tmp, []byte(strconv.FormatUint(v, 10)), 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, n.path)
+}
+
+var (
+	sharedNoncesMu sync.Mutex
+	sharedNonces   = map[string]*MonotonicNonce{}
+)
+
+// sharedNonce returns the in-memory nonce source used by all clients created for the same key.
+func sharedNonce(key string) *MonotonicNonce {
+	sharedNoncesMu.Lock()
+	defer sharedNoncesMu.Unlock()
+
+	n, ok := sharedNonces[key]
+	if !ok {
+		n, _ = NewMonotonicNonce("")
+		sharedNonces[key] = n
+	}
+
+	return n
+}
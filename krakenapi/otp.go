@@ -0,0 +1,50 @@
+package krakenapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OTPSource returns the two-factor password that is sent along with every private request
+// of an API key that is protected by one.
+type OTPSource func() (string, error)
+
+// StaticOTP returns an OTPSource for an API key that is protected by a static password.
+func StaticOTP(password string) OTPSource {
+	return func() (string, error) {
+		return password, nil
+	}
+}
+
+// TOTP returns an OTPSource that generates time based one-time passwords (RFC 6238) from the
+// base32 encoded secret that Kraken shows when setting up an authenticator app for the API key.
+func TOTP(secret string) OTPSource {
+	return func() (string, error) {
+		return totpCode(secret, time.Now())
+	}
+}
+
+func totpCode(secret string, t time.Time) (string, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return "", fmt.Errorf("invalid totp secret: %w", err)
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/30))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
@@ -525,6 +525,66 @@ type WithdrawInfoResponse struct {
 	Fee    big.Float `json:"fee"`
 }
 
+// TransferStatus describes a deposit or withdrawal as returned by DepositStatus and WithdrawStatus.
+type TransferStatus struct {
+	Method     string `json:"method"`
+	Network    string `json:"network,omitempty"`
+	Aclass     string `json:"aclass"`
+	Asset      string `json:"asset"`
+	RefID      string `json:"refid"`
+	TxID       string `json:"txid"`
+	Info       string `json:"info"` // Address the funds were sent to.
+	Amount     string `json:"amount"`
+	Fee        string `json:"fee"`
+	Time       int64  `json:"time"`
+	Status     string `json:"status"`
+	StatusProp string `json:"status-prop,omitempty"`
+	Key        string `json:"key,omitempty"` // Name of the withdrawal key, withdrawals only.
+}
+
+// Final tells if the status of the transfer won't change anymore.
+func (s TransferStatus) Final() bool {
+	return s.Status == TransferSuccess || s.Status == TransferFailure
+}
+
+// Transfer states as reported in TransferStatus.Status.
+const (
+	TransferInitial = "Initial"
+	TransferPending = "Pending"
+	TransferSettled = "Settled"
+	TransferSuccess = "Success"
+	TransferFailure = "Failure"
+)
+
+// Additional transfer states as reported in TransferStatus.StatusProp.
+const (
+	TransferReturn        = "return"
+	TransferOnHold        = "onhold"
+	TransferCancelPending = "cancel-pending"
+	TransferCanceled      = "canceled"
+	TransferCancelDenied  = "cancel-denied"
+)
+
+// DepositStatusResponse is the response type of a DepositStatus query to the Kraken API.
+type DepositStatusResponse []TransferStatus
+
+// WithdrawStatusResponse is the response type of a WithdrawStatus query to the Kraken API.
+type WithdrawStatusResponse []TransferStatus
+
+// WithdrawAddress is a withdrawal address saved in the account under a name (key).
+type WithdrawAddress struct {
+	Address  string `json:"address"`
+	Asset    string `json:"asset"`
+	Method   string `json:"method"`
+	Key      string `json:"key"`
+	Memo     string `json:"memo,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	Verified bool   `json:"verified"`
+}
+
+// WithdrawAddressesResponse is the response type of a WithdrawAddresses query to the Kraken API.
+type WithdrawAddressesResponse []WithdrawAddress
+
 // GetPairTickerInfo is a helper method that returns given `pair`'s `PairTickerInfo`
 func (v *TickerResponse) GetPairTickerInfo(pair string) PairTickerInfo {
 	r := reflect.ValueOf(v)
@@ -580,8 +640,21 @@ type TradeHistoryInfo struct {
 	Fee           float64  `json:"fee,string"`
 	Volume        float64  `json:"vol,string"`
 	Margin        float64  `json:"margin,string"`
+	Leverage      string   `json:"leverage"`
 	Misc          string   `json:"misc"`
 	Ledgers       []string `json:"ledgers"`
+	TradeID       int64    `json:"trade_id"`
+	// Maker is true if the fill added liquidity to the order book. It's missing for older trades.
+	Maker *bool `json:"maker,omitempty"`
+	// Position fields, only set for trades that opened a margin position.
+	PosStatus    string   `json:"posstatus,omitempty"`
+	ClosedPrice  float64  `json:"cprice,string,omitempty"`
+	ClosedCost   float64  `json:"ccost,string,omitempty"`
+	ClosedFee    float64  `json:"cfee,string,omitempty"`
+	ClosedVolume float64  `json:"cvol,string,omitempty"`
+	ClosedMargin float64  `json:"cmargin,string,omitempty"`
+	Net          float64  `json:"net,string,omitempty"`
+	Trades       []string `json:"trades,omitempty"`
 }
 
 // TradeInfo represents a trades information
@@ -608,6 +681,7 @@ type LedgerInfo struct {
 	RefID   string    `json:"refid"`
 	Time    float64   `json:"time"`
 	Type    string    `json:"type"`
+	Subtype string    `json:"subtype"`
 	Aclass  string    `json:"aclass"`
 	Asset   string    `json:"asset"`
 	Amount  big.Float `json:"amount"`
@@ -5,13 +5,23 @@ import (
 	"embed"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"os"
 	"time"
 
+	"github.com/f-taxes/kraken_import/accounts"
+	"github.com/f-taxes/kraken_import/cli"
 	"github.com/f-taxes/kraken_import/conf"
 	"github.com/f-taxes/kraken_import/ctl"
+	"github.com/f-taxes/kraken_import/fetcher"
 	"github.com/f-taxes/kraken_import/global"
 	g "github.com/f-taxes/kraken_import/grpc_client"
+	"github.com/f-taxes/kraken_import/outbox"
+	"github.com/f-taxes/kraken_import/preview"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/f-taxes/kraken_import/sink"
+	"github.com/f-taxes/kraken_import/store"
+	"github.com/f-taxes/kraken_import/vault"
 	"github.com/f-taxes/kraken_import/web"
 	"github.com/kataras/golog"
 )
@@ -19,6 +29,11 @@ import (
 //go:embed frontend-dist/*
 var WebAssets embed.FS
 
+// AssetsSnapshot is a copy of krakens list of assets that the registry falls back to.
+//
+//go:embed assets.json
+var AssetsSnapshot []byte
+
 func init() {
 	manifestContent, err := os.ReadFile("./manifest.json")
 
@@ -37,26 +52,89 @@ func init() {
 
 func main() {
 	grpcAddress := flag.String("grpc-addr", "127.0.0.1:4222", "GRPC address of the f-taxes server that the plugin will attempt to connect to.")
+	sinkKind := flag.String("sink", "grpc", "Where fetched records are written to: grpc (f-taxes), jsonl or csv. File sinks run the plugin without f-taxes.")
+	sinkOut := flag.String("out", "./records.jsonl", "Output file of the jsonl and csv sinks.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command]\n\nWithout a command the web and ctl servers are started.\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output())
+		cli.Usage()
+	}
 	flag.Parse()
 
 	ctx := context.Background()
-	g.GrpcClient = g.NewFTaxesClient(*grpcAddress)
-
-	err := g.GrpcClient.Connect(ctx)
 
+	recordSink, err := sink.Open(*sinkKind, *sinkOut)
 	if err != nil {
 		golog.Fatal(err)
 	}
 
-	go func() {
-		for {
-			g.GrpcClient.PluginHeartbeat(context.Background())
-			time.Sleep(time.Second * 5)
+	if _, headless := recordSink.(*sink.File); headless {
+		golog.Infof("Running without f-taxes, records are written to %s", *sinkOut)
+	} else {
+		g.GrpcClient = g.NewFTaxesClient(*grpcAddress)
+
+		if err := g.GrpcClient.Connect(ctx); err != nil {
+			golog.Fatal(err)
 		}
-	}()
+	}
 
 	conf.LoadAppConfig("config.yaml")
 
+	if err := vault.Init(); err != nil {
+		golog.Fatalf("Failed to unlock credentials: %v", err)
+	}
+
+	accounts.Repo = accounts.Open(conf.App.String("accountsFile", "./accounts.json"))
+
+	if err := conf.Migrate(); err != nil {
+		golog.Fatalf("Failed to migrate config: %v", err)
+	}
+
+	if err := store.Open("./data/db"); err != nil {
+		golog.Fatalf("Failed to open local database: %v", err)
+	}
+
+	if err := fetcher.InitRegistry(store.DB, AssetsSnapshot); err != nil {
+		golog.Fatalf("Failed to load the registry of assets and pairs: %v", err)
+	}
+
+	fetcher.InitTransfers(store.DB)
+
+	outbox.Default = outbox.New(store.DB, recordSink)
+	outbox.Default.OnDelivered(func(b outbox.Batch) {
+		if b.WindowEnd.IsZero() {
+			return
+		}
+
+		if err := accounts.Repo.SetLastFetched(b.AccountID, b.WindowEnd); err != nil {
+			golog.Errorf("Failed to store fetch time of account %s: %v", b.AccountID, err)
+		}
+	})
+	outbox.Default.OnRecordDelivered(func(b outbox.Batch, rec *proto.Record) {
+		fetcher.Transfers.Delivered(outbox.Default, b, rec)
+	})
+
+	preview.Default = preview.New(store.DB)
+
+	// Subcommands run once and exit without starting the servers.
+	if flag.NArg() > 0 {
+		code := cli.Run(ctx, flag.Args())
+		store.Close()
+		os.Exit(code)
+	}
+
+	if !g.GrpcClient.Offline() {
+		go func() {
+			for {
+				g.GrpcClient.PluginHeartbeat(context.Background())
+				time.Sleep(time.Second * 5)
+			}
+		}()
+	}
+
+	go outbox.Default.Run(ctx, time.Minute)
+
 	go web.Start(global.Plugin.Web.Address, WebAssets)
 
 	ctl.Start(global.Plugin.Ctl.Address)
@@ -0,0 +1,347 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/f-taxes/kraken_import/sink"
+	"github.com/f-taxes/kraken_import/store"
+	"github.com/kataras/golog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	pb "google.golang.org/protobuf/proto"
+)
+
+// Default is the outbox used by the web and ctl servers.
+var Default *Outbox
+
+// ErrQueued is returned by Commit if the records of a batch couldn't all be delivered yet.
+var ErrQueued = errors.New("the records have been fetched but couldn't be delivered yet. They are queued and delivery will be retried automatically")
+
+const (
+	batchPrefix  = "outbox:batch:"
+	recordPrefix = "outbox:rec:"
+)
+
+// Outbox persists every record before it is delivered and only forgets it once the delivery has been acknowledged.
+// Records are grouped into batches, one per fetched time window of an account. The window is reported as
+// delivered (see OnDelivered) only after every record of a sealed batch has been acknowledged.
+type Outbox struct {
+	db          *badger.DB
+	sink        sink.Sink
+	onDelivered func(b Batch)
+	onRecord    func(b Batch, rec *proto.Record)
+	flushMu     sync.Mutex
+	openMu      sync.Mutex
+	open        map[string]struct{} // Batches that are still being filled by a running fetch.
+
+	Attempts int           // Number of delivery attempts per record and flush.
+	Deadline time.Duration // Deadline of a single delivery attempt.
+}
+
+// Batch is a group of records that belong to the same fetch window of an account.
+type Batch struct {
+	ID        string    `json:"id"`
+	AccountID string    `json:"accountId"`
+	WindowEnd time.Time `json:"windowEnd"` // The account's new LastFetched value once the batch has been delivered. Zero leaves it as it is.
+	Sealed    bool      `json:"sealed"`    // True once all records of the window have been enqueued.
+	Created   time.Time `json:"created"`
+
+	outbox *Outbox
+	seq    int
+}
+
+// New creates an outbox that stores its records in db and delivers them to s.
+func New(db *badger.DB, s sink.Sink) *Outbox {
+	return &Outbox{
+		db:       db,
+		sink:     s,
+		open:     map[string]struct{}{},
+		Attempts: 5,
+		Deadline: 10 * time.Second,
+	}
+}
+
+// OnDelivered registers a function that is called whenever all records of a sealed batch have been delivered.
+func (o *Outbox) OnDelivered(fn func(b Batch)) {
+	o.onDelivered = fn
+}
+
+// OnRecordDelivered registers a function that is called for every record once its delivery has been acknowledged.
+func (o *Outbox) OnRecordDelivered(fn func(b Batch, rec *proto.Record)) {
+	o.onRecord = fn
+}
+
+// Begin starts a new batch for the fetch window of an account that ends at windowEnd.
+func (o *Outbox) Begin(accountID string, windowEnd time.Time) (*Batch, error) {
+	b := &Batch{
+		ID:        primitive.NewObjectID().Hex(),
+		AccountID: accountID,
+		WindowEnd: windowEnd,
+		Created:   time.Now().UTC(),
+		outbox:    o,
+	}
+
+	o.openMu.Lock()
+	o.open[b.ID] = struct{}{}
+	o.openMu.Unlock()
+
+	return b, o.db.Update(func(txn *badger.Txn) error {
+		return store.PutJSON(txn, batchPrefix+b.ID, b)
+	})
+}
+
+func (o *Outbox) isOpen(batchID string) bool {
+	o.openMu.Lock()
+	defer o.openMu.Unlock()
+	_, ok := o.open[batchID]
+	return ok
+}
+
+func (o *Outbox) close(batchID string) {
+	o.openMu.Lock()
+	delete(o.open, batchID)
+	o.openMu.Unlock()
+}
+
+// SubmitTrade queues a trade for delivery.
+func (b *Batch) SubmitTrade(ctx context.Context, t *proto.Trade) error {
+	return b.enqueue(&proto.Record{Trade: t})
+}
+
+// SubmitTransfer queues a transfer for delivery.
+func (b *Batch) SubmitTransfer(ctx context.Context, t *proto.Transfer) error {
+	return b.enqueue(&proto.Record{Transfer: t})
+}
+
+// Enqueue queues records of an account outside of a fetch, e.g. records that have changed since they were delivered.
+// They are delivered by the next Flush and leave the account's fetch time as it is.
+func (o *Outbox) Enqueue(accountID string, recs ...*proto.Record) error {
+	b := &Batch{
+		ID:        primitive.NewObjectID().Hex(),
+		AccountID: accountID,
+		Sealed:    true,
+		Created:   time.Now().UTC(),
+	}
+
+	return o.db.Update(func(txn *badger.Txn) error {
+		for i, rec := range recs {
+			data, err := pb.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			if err := txn.Set([]byte(fmt.Sprintf("%s%s:%010d", recordPrefix, b.ID, i+1)), data); err != nil {
+				return err
+			}
+		}
+		return store.PutJSON(txn, batchPrefix+b.ID, b)
+	})
+}
+
+func (b *Batch) enqueue(rec *proto.Record) error {
+	data, err := pb.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	b.seq++
+	key := fmt.Sprintf("%s%s:%010d", recordPrefix, b.ID, b.seq)
+
+	return b.outbox.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(key), data)
+	})
+}
+
+// Commit seals the batch, which marks its window as complete, and tries to deliver it right away.
+// If the delivery fails, ErrQueued is returned. The records stay queued and are retried by Run or the next Flush.
+func (b *Batch) Commit(ctx context.Context) error {
+	b.Sealed = true
+
+	err := b.outbox.db.Update(func(txn *badger.Txn) error {
+		return store.PutJSON(txn, batchPrefix+b.ID, b)
+	})
+	if err != nil {
+		return err
+	}
+
+	b.outbox.close(b.ID)
+
+	if err := b.outbox.Flush(ctx, b.AccountID); err != nil {
+		return fmt.Errorf("%w: %v", ErrQueued, err)
+	}
+
+	return nil
+}
+
+// Abort closes a batch whose fetch failed. Records that have been queued so far are still delivered,
+// but the window is incomplete and won't be reported as delivered.
+func (b *Batch) Abort() {
+	b.outbox.close(b.ID)
+}
+
+// Pending returns the number of records of an account that haven't been delivered yet. An empty account id counts all records.
+func (o *Outbox) Pending(accountID string) (int, error) {
+	count := 0
+
+	err := o.db.View(func(txn *badger.Txn) error {
+		for _, b := range o.batches(txn, accountID) {
+			count += len(store.Keys(txn, recordPrefix+b.ID+":"))
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// Flush delivers all queued records of an account in the order they were enqueued. An empty account id flushes all accounts.
+// Flushing stops at the first record that can't be delivered so that the order of records is kept.
+func (o *Outbox) Flush(ctx context.Context, accountID string) error {
+	o.flushMu.Lock()
+	defer o.flushMu.Unlock()
+
+	batches := []Batch{}
+	o.db.View(func(txn *badger.Txn) error {
+		batches = o.batches(txn, accountID)
+		return nil
+	})
+
+	for _, b := range batches {
+		if err := o.flushBatch(ctx, b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *Outbox) flushBatch(ctx context.Context, b Batch) error {
+	keys := []string{}
+	o.db.View(func(txn *badger.Txn) error {
+		keys = store.Keys(txn, recordPrefix+b.ID+":")
+		return nil
+	})
+
+	for i, key := range keys {
+		if err := o.deliverRecord(ctx, b, key); err != nil {
+			return fmt.Errorf("failed to deliver queued record (%d left in batch %s): %w", len(keys)-i, b.ID, err)
+		}
+	}
+
+	// A fetch is still adding records to the batch.
+	if o.isOpen(b.ID) {
+		return nil
+	}
+
+	err := o.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(batchPrefix + b.ID))
+	})
+	if err != nil {
+		return err
+	}
+
+	// Unsealed batches are left over from a fetch that didn't finish. Their window is incomplete and must not be reported.
+	if b.Sealed && o.onDelivered != nil {
+		o.onDelivered(b)
+	}
+
+	return nil
+}
+
+func (o *Outbox) deliverRecord(ctx context.Context, b Batch, key string) error {
+	rec := &proto.Record{}
+
+	err := o.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return pb.Unmarshal(val, rec)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, o.Deadline)
+		err = sink.Submit(attemptCtx, o.sink, rec)
+		cancel()
+
+		if err == nil {
+			break
+		}
+
+		if attempt >= o.Attempts || ctx.Err() != nil {
+			return err
+		}
+
+		golog.Debugf("Delivery of %s failed (attempt %d): %v", key, attempt, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+
+	// Acknowledge
+	err = o.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(key))
+	})
+	if err != nil {
+		return err
+	}
+
+	if o.onRecord != nil {
+		o.onRecord(b, rec)
+	}
+
+	return nil
+}
+
+// batches returns the batches of an account (or all accounts) in the order they were created.
+func (o *Outbox) batches(txn *badger.Txn, accountID string) []Batch {
+	list := []Batch{}
+
+	for _, key := range store.Keys(txn, batchPrefix) {
+		b := Batch{}
+		if err := store.GetJSON(txn, key, &b); err != nil {
+			golog.Errorf("Skipping unreadable outbox batch %s: %v", strings.TrimPrefix(key, batchPrefix), err)
+			continue
+		}
+
+		if accountID == "" || b.AccountID == accountID {
+			b.outbox = o
+			list = append(list, b)
+		}
+	}
+
+	return list
+}
+
+// Run retries the delivery of queued records periodically until ctx is cancelled.
+func (o *Outbox) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, _ := o.Pending(""); n == 0 {
+				continue
+			}
+
+			if err := o.Flush(ctx, ""); err != nil {
+				golog.Warnf("Outbox: %v", err)
+			}
+		}
+	}
+}
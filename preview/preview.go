@@ -0,0 +1,222 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/f-taxes/kraken_import/outbox"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/f-taxes/kraken_import/sink"
+	"github.com/f-taxes/kraken_import/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	pb "google.golang.org/protobuf/proto"
+)
+
+// Default is the preview store used by the web server and the cli.
+var Default *Store
+
+var ErrNotFound = errors.New("preview not found")
+
+const (
+	batchPrefix  = "preview:batch:"
+	recordPrefix = "preview:rec:"
+)
+
+// Store keeps the records of dry runs until they are committed to the outbox or discarded.
+type Store struct {
+	db *badger.DB
+}
+
+// Batch holds the records a dry run of a fetch or csv import has built.
+type Batch struct {
+	ID           string    `json:"id"`
+	AccountID    string    `json:"accountId"`
+	AccountLabel string    `json:"accountLabel"`
+	Source       string    `json:"source"`    // "fetch" or "csv".
+	WindowEnd    time.Time `json:"windowEnd"` // Passed on to the outbox batch when the preview is committed.
+	Created      time.Time `json:"created"`
+	Records      int       `json:"records"`
+	Complete     bool      `json:"complete"` // False while the dry run is still running or if it failed.
+
+	store *Store
+}
+
+func New(db *badger.DB) *Store {
+	return &Store{db: db}
+}
+
+// Begin starts a new preview batch for an account.
+func (s *Store) Begin(accountID, accountLabel, source string, windowEnd time.Time) (*Batch, error) {
+	b := &Batch{
+		ID:           primitive.NewObjectID().Hex(),
+		AccountID:    accountID,
+		AccountLabel: accountLabel,
+		Source:       source,
+		WindowEnd:    windowEnd,
+		Created:      time.Now().UTC(),
+		store:        s,
+	}
+
+	return b, b.save()
+}
+
+func (b *Batch) save() error {
+	return b.store.db.Update(func(txn *badger.Txn) error {
+		return store.PutJSON(txn, batchPrefix+b.ID, b)
+	})
+}
+
+// SubmitTrade stores a trade in the preview.
+func (b *Batch) SubmitTrade(ctx context.Context, t *proto.Trade) error {
+	return b.add(&proto.Record{Trade: t})
+}
+
+// SubmitTransfer stores a transfer in the preview.
+func (b *Batch) SubmitTransfer(ctx context.Context, t *proto.Transfer) error {
+	return b.add(&proto.Record{Transfer: t})
+}
+
+func (b *Batch) add(rec *proto.Record) error {
+	data, err := pb.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	b.Records++
+	key := fmt.Sprintf("%s%s:%010d", recordPrefix, b.ID, b.Records)
+
+	return b.store.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(key), data)
+	})
+}
+
+// Commit marks the dry run as complete. Nothing is delivered, see Store.Commit.
+func (b *Batch) Commit(ctx context.Context) error {
+	b.Complete = true
+	return b.save()
+}
+
+// Abort discards the records of a failed dry run.
+func (b *Batch) Abort() {
+	b.store.Discard(b.ID)
+}
+
+// List returns all previews, oldest first.
+func (s *Store) List() ([]Batch, error) {
+	list := []Batch{}
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		for _, key := range store.Keys(txn, batchPrefix) {
+			b := Batch{}
+			if err := store.GetJSON(txn, key, &b); err != nil {
+				return err
+			}
+			list = append(list, b)
+		}
+		return nil
+	})
+
+	return list, err
+}
+
+// Get returns a single preview.
+func (s *Store) Get(id string) (Batch, error) {
+	b := Batch{}
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		return store.GetJSON(txn, batchPrefix+id, &b)
+	})
+
+	if store.IsNotFound(err) {
+		return b, ErrNotFound
+	}
+
+	b.store = s
+	return b, err
+}
+
+// records returns all records of a preview in the order they were built.
+func (s *Store) records(id string) ([]*proto.Record, error) {
+	recs := []*proto.Record{}
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		for _, key := range store.Keys(txn, recordPrefix+id+":") {
+			item, err := txn.Get([]byte(key))
+			if err != nil {
+				return err
+			}
+
+			rec := &proto.Record{}
+			if err := item.Value(func(val []byte) error { return pb.Unmarshal(val, rec) }); err != nil {
+				return err
+			}
+
+			recs = append(recs, rec)
+		}
+		return nil
+	})
+
+	return recs, err
+}
+
+// Commit hands the records of a complete preview over to the outbox and removes the preview.
+// Like outbox.Batch.Commit it returns outbox.ErrQueued if the records couldn't be delivered right away.
+func (s *Store) Commit(ctx context.Context, ob *outbox.Outbox, id string) error {
+	b, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if !b.Complete {
+		return fmt.Errorf("the dry run of preview %s didn't finish, please discard it", id)
+	}
+
+	recs, err := s.records(id)
+	if err != nil {
+		return err
+	}
+
+	batch, err := ob.Begin(b.AccountID, b.WindowEnd)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range recs {
+		if err := sink.Submit(ctx, batch, rec); err != nil {
+			batch.Abort()
+			return err
+		}
+	}
+
+	// The records are safe in the outbox from here on.
+	if err := s.Discard(id); err != nil {
+		batch.Abort()
+		return err
+	}
+
+	return batch.Commit(ctx)
+}
+
+// Discard removes a preview and all of its records.
+func (s *Store) Discard(id string) error {
+	keys := []string{}
+	s.db.View(func(txn *badger.Txn) error {
+		keys = store.Keys(txn, recordPrefix+id+":")
+		return nil
+	})
+
+	// A write batch splits large previews into several transactions.
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, key := range append(keys, batchPrefix+id) {
+		if err := wb.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+
+	return wb.Flush()
+}
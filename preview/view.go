@@ -0,0 +1,217 @@
+package preview
+
+import (
+	"strings"
+	"time"
+
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/shopspring/decimal"
+)
+
+// Filter selects the records of a preview that are returned by Records.
+type Filter struct {
+	Kind         string `json:"kind"`         // "trade", "transfer" or empty for both.
+	Action       string `json:"action"`       // e.g. "BUY" or "DEPOSIT".
+	Asset        string `json:"asset"`        // Matches the asset, quote or fee currency.
+	Search       string `json:"search"`       // Substring of the transaction id or comment.
+	WarningsOnly bool   `json:"warningsOnly"` // Only records with at least one warning.
+	Offset       int    `json:"offset"`
+	Limit        int    `json:"limit"` // Defaults to 100.
+}
+
+// Row is the flattened view of a trade or transfer.
+type Row struct {
+	Kind        string    `json:"kind"`
+	TxID        string    `json:"txId"`
+	Ts          time.Time `json:"ts"`
+	Action      string    `json:"action"`
+	Asset       string    `json:"asset"`
+	Quote       string    `json:"quote,omitempty"`
+	Amount      string    `json:"amount"`
+	Price       string    `json:"price,omitempty"`
+	Value       string    `json:"value,omitempty"`
+	Fee         string    `json:"fee"`
+	FeeCurrency string    `json:"feeCurrency"`
+	QuoteFee    string    `json:"quoteFee,omitempty"`
+	Comment     string    `json:"comment,omitempty"`
+	Warnings    []string  `json:"warnings,omitempty"`
+}
+
+// Page is the result of Records. Counts and Warnings cover the whole preview, Total only the filtered records.
+type Page struct {
+	Batch    Batch          `json:"batch"`
+	Counts   map[string]int `json:"counts"`   // Number of records by type ("trade", "transfer") and by action ("BUY", "DEPOSIT", ...).
+	Warnings int            `json:"warnings"` // Number of records with at least one warning.
+	Total    int            `json:"total"`
+	Rows     []Row          `json:"rows"`
+}
+
+// Records returns a filtered page of the records of a preview.
+func (s *Store) Records(id string, f Filter) (*Page, error) {
+	b, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	recs, err := s.records(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+
+	page := &Page{Batch: b, Counts: map[string]int{}, Rows: []Row{}}
+	seen := map[string]int{}
+
+	for _, rec := range recs {
+		seen[txID(rec)]++
+	}
+
+	for _, rec := range recs {
+		row := toRow(rec)
+
+		if seen[row.TxID] > 1 {
+			row.Warnings = append(row.Warnings, "The transaction id occurs more than once in this preview.")
+		}
+
+		page.Counts[row.Kind]++
+		page.Counts[row.Action]++
+		if len(row.Warnings) > 0 {
+			page.Warnings++
+		}
+
+		if !f.matches(row) {
+			continue
+		}
+
+		if page.Total >= f.Offset && len(page.Rows) < f.Limit {
+			page.Rows = append(page.Rows, row)
+		}
+		page.Total++
+	}
+
+	return page, nil
+}
+
+func (f Filter) matches(r Row) bool {
+	if f.Kind != "" && f.Kind != r.Kind {
+		return false
+	}
+
+	if f.Action != "" && !strings.EqualFold(f.Action, r.Action) {
+		return false
+	}
+
+	if f.Asset != "" && !strings.EqualFold(f.Asset, r.Asset) && !strings.EqualFold(f.Asset, r.Quote) && !strings.EqualFold(f.Asset, r.FeeCurrency) {
+		return false
+	}
+
+	if f.Search != "" {
+		q := strings.ToLower(f.Search)
+		if !strings.Contains(strings.ToLower(r.TxID), q) && !strings.Contains(strings.ToLower(r.Comment), q) {
+			return false
+		}
+	}
+
+	return !f.WarningsOnly || len(r.Warnings) > 0
+}
+
+func txID(rec *proto.Record) string {
+	if rec.Trade != nil {
+		return rec.Trade.TxID
+	}
+
+	return rec.Transfer.TxID
+}
+
+func toRow(rec *proto.Record) Row {
+	if t := rec.Trade; t != nil {
+		r := Row{
+			Kind:        "trade",
+			TxID:        t.TxID,
+			Ts:          t.Ts.AsTime(),
+			Action:      t.Action.String(),
+			Asset:       t.Asset,
+			Quote:       t.Quote,
+			Amount:      t.Amount,
+			Price:       t.Price,
+			Value:       t.Value,
+			Fee:         t.Fee,
+			FeeCurrency: t.FeeCurrency,
+			QuoteFee:    t.QuoteFee,
+			Comment:     t.Comment,
+		}
+
+		r.Warnings = tradeWarnings(t)
+		return r
+	}
+
+	t := rec.Transfer
+	return Row{
+		Kind:        "transfer",
+		TxID:        t.TxID,
+		Ts:          t.Ts.AsTime(),
+		Action:      t.Action.String(),
+		Asset:       t.Asset,
+		Amount:      t.Amount,
+		Fee:         t.Fee,
+		FeeCurrency: t.FeeCurrency,
+		Comment:     t.Comment,
+		Warnings:    transferWarnings(t),
+	}
+}
+
+func tradeWarnings(t *proto.Trade) []string {
+	w := []string{}
+
+	if t.Ts == nil || t.Ts.AsTime().Unix() <= 0 {
+		w = append(w, "The trade has no timestamp.")
+	}
+
+	if t.Asset == "" || t.Quote == "" {
+		w = append(w, "Asset or quote currency is missing.")
+	}
+
+	if isZero(t.Amount) {
+		w = append(w, "The amount is zero.")
+	}
+
+	if isZero(t.Price) {
+		w = append(w, "The price is zero.")
+	}
+
+	if t.AssetDecimals == 0 || t.QuoteDecimals == 0 {
+		w = append(w, "The decimals of the asset or quote currency are unknown.")
+	}
+
+	return w
+}
+
+func transferWarnings(t *proto.Transfer) []string {
+	w := []string{}
+
+	if t.Ts == nil || t.Ts.AsTime().Unix() <= 0 {
+		w = append(w, "The transfer has no timestamp.")
+	}
+
+	if t.Asset == "" {
+		w = append(w, "The asset is missing.")
+	}
+
+	if isZero(t.Amount) {
+		w = append(w, "The amount is zero.")
+	}
+
+	if t.AssetDecimals == 0 {
+		w = append(w, "The decimals of the asset are unknown.")
+	}
+
+	return w
+}
+
+func isZero(v string) bool {
+	d, err := decimal.NewFromString(v)
+	return err != nil || d.IsZero()
+}
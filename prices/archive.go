@@ -0,0 +1,167 @@
+package prices
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArchiveResult summarizes the import of an OHLCVT archive.
+type ArchiveResult struct {
+	Files   int
+	Candles int
+	Skipped []string // Files that don't belong to a known pair or interval.
+}
+
+// ImportArchive imports Kraken's downloadable OHLCVT history into the price history.
+// path can be the zip archive, a directory of extracted files or a single file. Files are named
+// <PAIR>_<INTERVAL>.csv (e.g. XBTEUR_60.csv) and contain rows of time,open,high,low,close,volume,trades
+// without a header.
+func ImportArchive(m *Market, h *History, path string, progress func(file string)) (*ArchiveResult, error) {
+	res := &ArchiveResult{}
+
+	importFile := func(name string, open func() (io.ReadCloser, error)) error {
+		pair, interval, ok := archiveFile(m, name)
+		if !ok {
+			res.Skipped = append(res.Skipped, name)
+			return nil
+		}
+
+		if progress != nil {
+			progress(name)
+		}
+
+		r, err := open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		n, err := importCandles(h, pair, interval, r)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		res.Files++
+		res.Candles += n
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case info.IsDir():
+		err = filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			return importFile(filepath.Base(p), func() (io.ReadCloser, error) { return os.Open(p) })
+		})
+	case strings.EqualFold(filepath.Ext(path), ".zip"):
+		var zr *zip.ReadCloser
+		zr, err = zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			if err = importFile(filepath.Base(f.Name), f.Open); err != nil {
+				break
+			}
+		}
+	default:
+		err = importFile(filepath.Base(path), func() (io.ReadCloser, error) { return os.Open(path) })
+	}
+
+	return res, err
+}
+
+// archiveFile resolves the pair and interval of an archive file name.
+func archiveFile(m *Market, name string) (pair string, interval int, ok bool) {
+	base, ext, _ := strings.Cut(name, ".")
+	if !strings.EqualFold(ext, "csv") {
+		return "", 0, false
+	}
+
+	i := strings.LastIndex(base, "_")
+	if i < 0 {
+		return "", 0, false
+	}
+
+	interval, err := strconv.Atoi(base[i+1:])
+	if err != nil || interval <= 0 {
+		return "", 0, false
+	}
+
+	pair, ok = m.FindPair(base[:i])
+	return pair, interval, ok
+}
+
+const archiveBatchSize = 50_000
+
+func importCandles(h *History, pair string, interval int, r io.Reader) (int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	batch := make([]Candle, 0, archiveBatchSize)
+	count := 0
+
+	flush := func() error {
+		if err := h.Put(pair, interval, batch); err != nil {
+			return err
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+
+		if len(row) < 6 {
+			return count, fmt.Errorf("line %d has %d columns, expected at least 6", line, len(row))
+		}
+
+		ts, err := strconv.ParseInt(row[0], 10, 64)
+		if err != nil {
+			return count, fmt.Errorf("line %d: invalid time %q", line, row[0])
+		}
+
+		closePrice, err := strconv.ParseFloat(row[4], 64)
+		if err != nil {
+			return count, fmt.Errorf("line %d: invalid close %q", line, row[4])
+		}
+
+		volume, _ := strconv.ParseFloat(row[5], 64)
+
+		batch = append(batch, Candle{Start: time.Unix(ts, 0).UTC(), Close: closePrice, Volume: volume})
+
+		if len(batch) == archiveBatchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+
+	return count, flush()
+}
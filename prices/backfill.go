@@ -0,0 +1,128 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/f-taxes/kraken_import/krakenapi"
+)
+
+// Backfill fills the price history of a pair in [from, to). The latest candles come from the OHLC endpoint,
+// older gaps are rebuilt from the public trades, which is slow for long spans. Kraken's OHLCVT archives
+// (see ImportArchive) are the faster way to get the full history.
+// progress, if set, is called after each page of trades with the time reached.
+func (s *KrakenSource) Backfill(ctx context.Context, pair string, interval int, from, to time.Time, progress func(reached time.Time)) (int, error) {
+	stored, err := s.fetchCandles(ctx, pair, interval)
+	if err != nil {
+		return 0, err
+	}
+
+	// Illiquid pairs have intervals without trades, rebuilding those again on every backfill gains nothing.
+	minGap := time.Duration(interval*backfillMinGap) * time.Minute
+	gaps, err := s.history.Gaps(pair, interval, from, to, minGap)
+	if err != nil {
+		return stored, err
+	}
+
+	for _, gap := range gaps {
+		n, err := s.backfillFromTrades(ctx, pair, interval, gap, progress)
+		stored += n
+		if err != nil {
+			return stored, err
+		}
+	}
+
+	return stored, nil
+}
+
+// backfillMinGap is the number of intervals without candles below which a span isn't backfilled.
+const backfillMinGap = 60
+
+func (s *KrakenSource) backfillFromTrades(ctx context.Context, pair string, interval int, gap Gap, progress func(reached time.Time)) (int, error) {
+	since := gap.From.UnixNano()
+	stored := 0
+
+	// The last candle of a page may continue on the next one, it is stored once a later one begins.
+	var open *candleAcc
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return stored, err
+		}
+
+		s.limiter.Take()
+		resp, err := s.api.Trades(ctx, pair, since)
+		if err != nil {
+			return stored, err
+		}
+
+		candles, next, done := candlesFromTrades(open, resp.Trades, interval, gap.To)
+		open = next
+		if (done || len(resp.Trades) == 0) && open != nil {
+			candles = append(candles, open.candle(interval))
+			open = nil
+		}
+
+		if err := s.history.Put(pair, interval, candles); err != nil {
+			return stored, err
+		}
+		stored += len(candles)
+
+		if len(resp.Trades) > 0 && progress != nil {
+			progress(time.Unix(resp.Trades[len(resp.Trades)-1].Time, 0))
+		}
+
+		if done || len(resp.Trades) == 0 {
+			return stored, nil
+		}
+
+		if resp.Last == 0 {
+			return stored, fmt.Errorf("kraken didn't return a position to continue from for %s", pair)
+		}
+		since = resp.Last
+	}
+}
+
+// candleAcc accumulates the trades of one candle.
+type candleAcc struct {
+	slot                 int64
+	close, value, volume float64
+}
+
+func (a *candleAcc) candle(interval int) Candle {
+	c := Candle{Start: time.Unix(a.slot*int64(interval*60), 0).UTC(), Close: a.close, Volume: a.volume}
+	if a.volume > 0 {
+		c.Vwap = a.value / a.volume
+	}
+	return c
+}
+
+// candlesFromTrades aggregates trades into candles of an interval, continuing the open candle of the
+// previous page. It returns the completed candles and the last one, which may continue on the next page.
+// Trades at or after until are ignored, done reports if any were reached.
+func candlesFromTrades(open *candleAcc, trades []krakenapi.TradeInfo, interval int, until time.Time) (candles []Candle, last *candleAcc, done bool) {
+	last = open
+
+	for _, tr := range trades {
+		t := time.Unix(tr.Time, 0)
+		if !t.Before(until) {
+			done = true
+			break
+		}
+
+		slot := slotOf(t, interval)
+		if last == nil || last.slot != slot {
+			if last != nil {
+				candles = append(candles, last.candle(interval))
+			}
+			last = &candleAcc{slot: slot}
+		}
+
+		last.close = tr.PriceFloat
+		last.value += tr.PriceFloat * tr.VolumeFloat
+		last.volume += tr.VolumeFloat
+	}
+
+	return candles, last, done
+}
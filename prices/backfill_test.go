@@ -0,0 +1,61 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/f-taxes/kraken_import/krakenapi"
+	"go.uber.org/ratelimit"
+)
+
+func TestBackfillMergesCandlesAcrossPages(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// A busy market: the first two pages fall into the same minute.
+	pages := []string{
+		fmt.Sprintf(`[["1.00", "1", %d, "b", "l", ""], ["2.00", "1", %d, "s", "l", ""]]`, t0.Add(5*time.Second).Unix(), t0.Add(20*time.Second).Unix()),
+		fmt.Sprintf(`[["4.00", "2", %d, "b", "l", ""]]`, t0.Add(40*time.Second).Unix()),
+		fmt.Sprintf(`[["5.00", "1", %d, "b", "l", ""], ["6.00", "1", %d, "b", "l", ""]]`, t0.Add(70*time.Second).Unix(), t0.Add(3*time.Minute).Unix()),
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := 0
+		fmt.Sscan(r.URL.Query().Get("since"), &page)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"error": [], "result": {"XYZEUR": %s, "last": "%d"}}`, pages[page], page+1)
+	}))
+	t.Cleanup(srv.Close)
+
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	h := NewHistory(db)
+	s := NewKrakenSource(krakenapi.New("", "").WithBaseURL(srv.URL), nil, h, nil)
+	s.limiter = ratelimit.NewUnlimited()
+
+	n, err := s.backfillFromTrades(context.Background(), "XYZEUR", 1, Gap{From: time.Unix(0, 0), To: t0.Add(2 * time.Minute)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("stored %d candles, want 2", n)
+	}
+
+	c, ok := h.Get("XYZEUR", 1, t0)
+	if !ok || c.Volume != 4 || c.Vwap != 2.75 || c.Close != 4 {
+		t.Errorf("12:00 candle = %+v, want volume 4, vwap 2.75 and close 4 from both pages", c)
+	}
+
+	c, ok = h.Get("XYZEUR", 1, t0.Add(time.Minute))
+	if !ok || c.Volume != 1 || c.Close != 5 {
+		t.Errorf("12:01 candle = %+v, want volume 1 and close 5", c)
+	}
+}
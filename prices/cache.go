@@ -0,0 +1,61 @@
+package prices
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/shopspring/decimal"
+)
+
+const cachePrefix = "prices:"
+
+// Cache stores prices in the local database so repeated conversions don't hit the api.
+type Cache struct {
+	db *badger.DB
+}
+
+func NewCache(db *badger.DB) *Cache {
+	return &Cache{db: db}
+}
+
+func tradeKey(pair string, t time.Time) string {
+	return fmt.Sprintf("%strade:%s:%d", cachePrefix, pair, t.Truncate(time.Minute).Unix())
+}
+
+func (c *Cache) get(key string) (decimal.Decimal, bool) {
+	var price decimal.Decimal
+	found := false
+
+	c.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			p, err := decimal.NewFromString(string(val))
+			if err == nil {
+				price = p
+				found = true
+			}
+			return err
+		})
+	})
+
+	return price, found
+}
+
+// put stores several prices at once. Keys map to prices.
+func (c *Cache) put(entries map[string]decimal.Decimal) error {
+	wb := c.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for key, price := range entries {
+		if err := wb.Set([]byte(key), []byte(price.String())); err != nil {
+			return err
+		}
+	}
+
+	return wb.Flush()
+}
@@ -0,0 +1,186 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/f-taxes/kraken_import/conf"
+	"github.com/f-taxes/kraken_import/fetcher"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/shopspring/decimal"
+)
+
+// Converter converts amounts between currencies using Kraken's historical market data.
+type Converter struct {
+	market *Market
+	source *KrakenSource
+}
+
+func NewConverter(market *Market, source *KrakenSource) *Converter {
+	return &Converter{market: market, source: source}
+}
+
+var (
+	defaultMu        sync.Mutex
+	defaultConverter *Converter
+)
+
+// Default returns the converter backed by the local database. The market is loaded on first use
+// and retried on the next call if that fails.
+func Default(ctx context.Context, db *badger.DB) (*Converter, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultConverter != nil {
+		return defaultConverter, nil
+	}
+
+	assets, pairs, err := fetcher.LoadMarket(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kraken's assets and pairs: %w", err)
+	}
+
+	source := NewKrakenSource(fetcher.PublicApi(), NewCache(db), NewHistory(db), NewTickStore(db, conf.App.Int("prices.ticks.maxBuckets", 100000)))
+	source.Offline = conf.App.Bool("prices.offline", false)
+
+	if conf.App.Bool("prices.ticks.enabled", false) {
+		source.TickTolerance = time.Duration(conf.App.Int("prices.ticks.tolerance", 60)) * time.Second
+	}
+
+	defaultConverter = NewConverter(NewMarket(assets, pairs).WithListings(fetcher.Reg.Listings()), source)
+	return defaultConverter, nil
+}
+
+func (c *Converter) Market() *Market {
+	return c.market
+}
+
+func (c *Converter) Source() *KrakenSource {
+	return c.source
+}
+
+// Rate returns how much one unit of from is worth in to at time t and a description of how the rate was determined.
+func (c *Converter) Rate(ctx context.Context, from, to string, t time.Time) (decimal.Decimal, string, error) {
+	if strings.EqualFold(from, to) {
+		return decimal.NewFromInt(1), "", nil
+	}
+
+	fromKey, ok := c.market.AssetKey(from)
+	if !ok {
+		return decimal.Zero, "", fmt.Errorf("%s isn't traded on kraken", from)
+	}
+
+	toKey, ok := c.market.AssetKey(to)
+	if !ok {
+		return decimal.Zero, "", fmt.Errorf("%s isn't traded on kraken", to)
+	}
+
+	if fromKey == toKey {
+		return decimal.NewFromInt(1), "", nil
+	}
+
+	// A route is skipped if one of its pairs has no price at t, e.g. because it was illiquid then.
+	avoid := map[string]bool{}
+	var noPrice error
+
+	for {
+		route, ok := c.market.RouteAvoiding(fromKey, toKey, t, avoid)
+		if !ok {
+			if noPrice != nil {
+				return decimal.Zero, "", noPrice
+			}
+			return decimal.Zero, "", fmt.Errorf("kraken has no markets to convert %s into %s at %s", from, to, t.UTC().Format(time.RFC3339))
+		}
+
+		rate, by, pair, err := c.rateAlong(ctx, route, t)
+		if !errors.Is(err, ErrNoPrice) {
+			return rate, by, err
+		}
+
+		if noPrice == nil {
+			noPrice = err
+		}
+		avoid[pair] = true
+	}
+}
+
+// rateAlong multiplies the prices of a route at time t. If a price is missing, pair is the one without it.
+func (c *Converter) rateAlong(ctx context.Context, route []Hop, t time.Time) (rate decimal.Decimal, by, pair string, err error) {
+	rate = decimal.NewFromInt(1)
+	path := make([]string, 0, len(route))
+
+	for _, hop := range route {
+		q, err := c.source.Price(ctx, hop.Pair, t)
+		if err != nil {
+			return decimal.Zero, "", hop.Pair, err
+		}
+
+		step := fmt.Sprintf("%s (%s)", c.market.PairName(hop.Pair), q.Source)
+
+		if hop.Inverted {
+			rate = rate.Div(q.Price)
+			step += " inverted"
+		} else {
+			rate = rate.Mul(q.Price)
+		}
+
+		path = append(path, step)
+	}
+
+	return rate, "Kraken " + strings.Join(path, " → "), "", nil
+}
+
+// ConvertTrade fills the converted prices, value and fees of a trade in the target currency.
+func (c *Converter) ConvertTrade(ctx context.Context, trade *proto.Trade, target string) error {
+	ts := trade.Ts.AsTime()
+
+	quoteRate, by, err := c.Rate(ctx, trade.Quote, target, ts)
+	if err != nil {
+		return err
+	}
+
+	trade.QuotePriceC = quoteRate.String()
+	trade.QuotePriceConvertedBy = by
+	trade.PriceC = mul(trade.Price, quoteRate)
+	trade.PriceConvertedBy = by
+	trade.ValueC = mul(trade.Value, quoteRate)
+
+	trade.FeePriceC, trade.FeeC, trade.FeeConvertedBy, err = c.convertFee(ctx, trade.Fee, trade.FeeCurrency, target, ts)
+	if err != nil {
+		return err
+	}
+
+	trade.QuoteFeePriceC, trade.QuoteFeeC, trade.QuoteFeeConvertedBy, err = c.convertFee(ctx, trade.QuoteFee, trade.QuoteFeeCurrency, target, ts)
+	return err
+}
+
+// ConvertTransfer fills the converted fee of a transfer in the target currency.
+func (c *Converter) ConvertTransfer(ctx context.Context, transfer *proto.Transfer, target string) error {
+	var err error
+	transfer.FeePriceC, transfer.FeeC, transfer.FeeConvertedBy, err = c.convertFee(ctx, transfer.Fee, transfer.FeeCurrency, target, transfer.Ts.AsTime())
+	return err
+}
+
+func (c *Converter) convertFee(ctx context.Context, fee, currency, target string, ts time.Time) (price, converted, by string, err error) {
+	amount, _ := decimal.NewFromString(fee)
+	if amount.IsZero() || currency == "" {
+		return "", "0", "", nil
+	}
+
+	rate, by, err := c.Rate(ctx, currency, target, ts)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return rate.String(), amount.Mul(rate).String(), by, nil
+}
+
+func mul(v string, rate decimal.Decimal) string {
+	d, _ := decimal.NewFromString(v)
+	return d.Mul(rate).String()
+}
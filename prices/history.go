@@ -0,0 +1,248 @@
+package prices
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const historyPrefix = cachePrefix + "candles:"
+
+// blockSize is the number of candle slots stored under a single key.
+const blockSize = 1024
+
+// Candle is a price candle of a pair. Only the values needed for conversions are kept.
+type Candle struct {
+	Start  time.Time
+	Close  float64
+	Vwap   float64 // Zero if unknown, e.g. for candles imported from Kraken's OHLCVT archives.
+	Volume float64
+}
+
+// Price is the volume weighted average price of the candle or its close if that isn't known.
+func (c Candle) Price() float64 {
+	if c.Vwap > 0 {
+		return c.Vwap
+	}
+
+	return c.Close
+}
+
+// Gap is a time span without any candles.
+type Gap struct {
+	From, To time.Time
+}
+
+// History stores candles per pair and interval in the local database.
+// Candles are grouped into blocks of consecutive slots, each block being one compactly encoded value:
+// per candle the slot within the block as uvarint followed by close, vwap and volume as 64 bit floats.
+// Slots without trades are simply absent.
+type History struct {
+	db *badger.DB
+}
+
+func NewHistory(db *badger.DB) *History {
+	return &History{db: db}
+}
+
+func slotOf(t time.Time, interval int) int64 {
+	return t.Unix() / int64(interval*60)
+}
+
+func blockKey(pair string, interval int, block int64) []byte {
+	return []byte(fmt.Sprintf("%s%s:%d:%012d", historyPrefix, pair, interval, block))
+}
+
+func encodeBlock(slots map[int64]Candle) []byte {
+	keys := make([]int64, 0, len(slots))
+	for s := range slots {
+		keys = append(keys, s)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	buf := make([]byte, 0, len(keys)*(2+3*8))
+	for _, s := range keys {
+		c := slots[s]
+		buf = binary.AppendUvarint(buf, uint64(s%blockSize))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(c.Close))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(c.Vwap))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(c.Volume))
+	}
+
+	return buf
+}
+
+func decodeBlock(buf []byte, block int64, interval int) (map[int64]Candle, error) {
+	slots := map[int64]Candle{}
+
+	for len(buf) > 0 {
+		offset, n := binary.Uvarint(buf)
+		if n <= 0 || len(buf) < n+24 {
+			return nil, errors.New("corrupt candle block")
+		}
+		buf = buf[n:]
+
+		slot := block*blockSize + int64(offset)
+		slots[slot] = Candle{
+			Start:  time.Unix(slot*int64(interval*60), 0).UTC(),
+			Close:  math.Float64frombits(binary.LittleEndian.Uint64(buf[0:])),
+			Vwap:   math.Float64frombits(binary.LittleEndian.Uint64(buf[8:])),
+			Volume: math.Float64frombits(binary.LittleEndian.Uint64(buf[16:])),
+		}
+		buf = buf[24:]
+	}
+
+	return slots, nil
+}
+
+func (h *History) readBlock(txn *badger.Txn, pair string, interval int, block int64) (map[int64]Candle, error) {
+	item, err := txn.Get(blockKey(pair, interval, block))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return map[int64]Candle{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var slots map[int64]Candle
+	err = item.Value(func(val []byte) error {
+		slots, err = decodeBlock(val, block, interval)
+		return err
+	})
+
+	return slots, err
+}
+
+// Put stores candles of a pair. Existing candles with the same start are replaced.
+func (h *History) Put(pair string, interval int, candles []Candle) error {
+	byBlock := map[int64][]Candle{}
+	for _, c := range candles {
+		s := slotOf(c.Start, interval)
+		byBlock[s/blockSize] = append(byBlock[s/blockSize], c)
+	}
+
+	for block, cs := range byBlock {
+		err := h.db.Update(func(txn *badger.Txn) error {
+			slots, err := h.readBlock(txn, pair, interval, block)
+			if err != nil {
+				return err
+			}
+
+			for _, c := range cs {
+				slots[slotOf(c.Start, interval)] = c
+			}
+
+			return txn.Set(blockKey(pair, interval, block), encodeBlock(slots))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns the candle of the given interval that contains t.
+func (h *History) Get(pair string, interval int, t time.Time) (Candle, bool) {
+	slot := slotOf(t, interval)
+	var c Candle
+	found := false
+
+	h.db.View(func(txn *badger.Txn) error {
+		slots, err := h.readBlock(txn, pair, interval, slot/blockSize)
+		if err != nil {
+			return err
+		}
+
+		c, found = slots[slot]
+		return nil
+	})
+
+	return c, found
+}
+
+// Candles returns all stored candles of a pair in [from, to) ordered by time.
+func (h *History) Candles(pair string, interval int, from, to time.Time) ([]Candle, error) {
+	first, last := slotOf(from, interval), slotOf(to.Add(-time.Second), interval)
+	candles := []Candle{}
+
+	err := h.db.View(func(txn *badger.Txn) error {
+		for block := first / blockSize; block <= last/blockSize; block++ {
+			slots, err := h.readBlock(txn, pair, interval, block)
+			if err != nil {
+				return err
+			}
+
+			for s, c := range slots {
+				if s >= first && s <= last {
+					candles = append(candles, c)
+				}
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(candles, func(i, j int) bool { return candles[i].Start.Before(candles[j].Start) })
+	return candles, err
+}
+
+// Gaps returns the time spans in [from, to) longer than minGap that have no candles.
+// Illiquid markets naturally have empty intervals, so minGap should be well above the interval.
+func (h *History) Gaps(pair string, interval int, from, to time.Time, minGap time.Duration) ([]Gap, error) {
+	candles, err := h.Candles(pair, interval, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	step := time.Duration(interval) * time.Minute
+	gaps := []Gap{}
+	next := from.Truncate(step)
+
+	for _, c := range candles {
+		if c.Start.Sub(next) > minGap {
+			gaps = append(gaps, Gap{From: next, To: c.Start})
+		}
+		next = c.Start.Add(step)
+	}
+
+	if to.Sub(next) > minGap {
+		gaps = append(gaps, Gap{From: next, To: to})
+	}
+
+	return gaps, nil
+}
+
+// Stored lists the pairs and intervals that have candles.
+func (h *History) Stored() (map[string][]int, error) {
+	stored := map[string][]int{}
+	seen := map[string]bool{}
+
+	err := h.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(historyPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			parts := strings.Split(strings.TrimPrefix(string(it.Item().Key()), historyPrefix), ":")
+			if len(parts) != 3 || seen[parts[0]+":"+parts[1]] {
+				continue
+			}
+			seen[parts[0]+":"+parts[1]] = true
+
+			var interval int
+			fmt.Sscan(parts[1], &interval)
+			stored[parts[0]] = append(stored[parts[0]], interval)
+		}
+		return nil
+	})
+
+	return stored, err
+}
@@ -0,0 +1,258 @@
+package prices
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/f-taxes/kraken_import/fetcher"
+)
+
+// Market knows the assets and pairs traded on Kraken and resolves currency names to them.
+type Market struct {
+	assets   map[string]fetcher.AssetInfo
+	pairs    map[string]fetcher.PairInfo
+	byName   map[string]string   // Currency name (BTC, XBT, XXBT, ...) -> asset key.
+	byAssets map[string]string   // "base/quote" asset keys -> pair key.
+	links    map[string][]string // Asset key -> asset keys it's directly traded against.
+
+	listings map[string]fetcher.Listing // Pair key -> when it was listed, if known.
+	periods  []time.Time                // The listing changes in order, routes stay the same between them.
+
+	routesMu sync.Mutex
+	routes   map[string][]Hop
+}
+
+// Hop is one step of a conversion route.
+type Hop struct {
+	From, To string // Asset keys.
+	Pair     string
+	Inverted bool // Set if the pair trades To against From, so its price has to be inverted.
+}
+
+// hubs are preferred as intermediate currencies when several routes of the same length exist,
+// because their markets are the most liquid.
+var hubs = []string{"ZUSD", "ZEUR", "XXBT", "XETH", "USDT"}
+
+func NewMarket(assets map[string]fetcher.AssetInfo, pairs map[string]fetcher.PairInfo) *Market {
+	m := &Market{
+		assets:   assets,
+		pairs:    pairs,
+		byName:   map[string]string{},
+		byAssets: map[string]string{},
+		links:    map[string][]string{},
+		routes:   map[string][]Hop{},
+	}
+
+	overrides := fetcher.CurrencyOverrides()
+	for key, a := range assets {
+		for _, name := range []string{key, a.Altname, fetcher.NormalizeCurrency(key, overrides), fetcher.NormalizeCurrency(a.Altname, overrides)} {
+			if _, ok := m.byName[strings.ToUpper(name)]; !ok {
+				m.byName[strings.ToUpper(name)] = key
+			}
+		}
+	}
+
+	for key, p := range pairs {
+		// Dark pool pairs share their market data with the regular pair.
+		if strings.HasSuffix(p.Altname, ".d") {
+			continue
+		}
+
+		if _, ok := m.byAssets[p.Base+"/"+p.Quote]; !ok {
+			m.links[p.Base] = append(m.links[p.Base], p.Quote)
+			m.links[p.Quote] = append(m.links[p.Quote], p.Base)
+		}
+
+		m.byAssets[p.Base+"/"+p.Quote] = key
+	}
+
+	for _, l := range m.links {
+		sort.Slice(l, func(i, j int) bool {
+			ri, rj := hubRank(l[i]), hubRank(l[j])
+			if ri != rj {
+				return ri < rj
+			}
+			return l[i] < l[j]
+		})
+	}
+
+	return m
+}
+
+// WithListings restricts routes to the pairs listed at the time of the conversion.
+func (m *Market) WithListings(listings map[string]fetcher.Listing) *Market {
+	m.routesMu.Lock()
+	defer m.routesMu.Unlock()
+
+	m.listings = listings
+	m.periods = nil
+	m.routes = map[string][]Hop{}
+
+	for _, l := range listings {
+		for _, t := range []time.Time{l.From, l.Until} {
+			if !t.IsZero() {
+				m.periods = append(m.periods, t)
+			}
+		}
+	}
+
+	sort.Slice(m.periods, func(i, j int) bool { return m.periods[i].Before(m.periods[j]) })
+	return m
+}
+
+// AssetKey returns the key of the asset a currency name refers to.
+func (m *Market) AssetKey(currency string) (string, bool) {
+	key, ok := m.byName[strings.ToUpper(currency)]
+	return key, ok
+}
+
+// Pair returns the key of the pair that trades base against quote (both asset keys).
+// If only the opposite pair exists, it is returned with inverted set.
+func (m *Market) Pair(base, quote string) (key string, inverted bool, ok bool) {
+	if key, ok := m.byAssets[base+"/"+quote]; ok {
+		return key, false, true
+	}
+
+	if key, ok := m.byAssets[quote+"/"+base]; ok {
+		return key, true, true
+	}
+
+	return "", false, false
+}
+
+// FindPair resolves a pair by its key, altname or websocket name (XXBTZEUR, XBTEUR, XBT/EUR)
+// or by the currencies it trades (BTC/EUR).
+func (m *Market) FindPair(name string) (string, bool) {
+	name = strings.ToUpper(name)
+
+	for key, p := range m.pairs {
+		if key == name || strings.ToUpper(p.Altname) == name || strings.ToUpper(p.Wsname) == name {
+			return key, true
+		}
+	}
+
+	base, quote, ok := strings.Cut(name, "/")
+	if !ok {
+		return "", false
+	}
+
+	baseKey, ok := m.AssetKey(base)
+	if !ok {
+		return "", false
+	}
+
+	quoteKey, ok := m.AssetKey(quote)
+	if !ok {
+		return "", false
+	}
+
+	key, ok := m.byAssets[baseKey+"/"+quoteKey]
+	return key, ok
+}
+
+// PairName returns the human readable name of a pair, e.g. XBT/EUR.
+func (m *Market) PairName(key string) string {
+	if p, ok := m.pairs[key]; ok && p.Wsname != "" {
+		return p.Wsname
+	}
+
+	return key
+}
+
+func hubRank(asset string) int {
+	for i, h := range hubs {
+		if h == asset {
+			return i
+		}
+	}
+
+	return len(hubs)
+}
+
+// Route returns the shortest chain of pairs listed at t that converts from into to (both asset keys).
+// A direct pair is a route with a single hop. An empty route means both are the same asset.
+func (m *Market) Route(from, to string, t time.Time) ([]Hop, bool) {
+	return m.RouteAvoiding(from, to, t, nil)
+}
+
+// RouteAvoiding is Route without the pairs in avoid, e.g. those that have no price at t.
+func (m *Market) RouteAvoiding(from, to string, t time.Time, avoid map[string]bool) ([]Hop, bool) {
+	if from == to {
+		return []Hop{}, true
+	}
+
+	m.routesMu.Lock()
+	defer m.routesMu.Unlock()
+
+	period := sort.Search(len(m.periods), func(i int) bool { return m.periods[i].After(t) })
+	cacheKey := fmt.Sprintf("%s/%s/%d", from, to, period)
+	if len(avoid) == 0 {
+		if r, ok := m.routes[cacheKey]; ok {
+			return r, r != nil
+		}
+	}
+
+	// Breadth first search, so the first path that reaches the target is one of the shortest.
+	prev := map[string]Hop{from: {}}
+	queue := []string{from}
+
+	for len(queue) > 0 {
+		if _, ok := prev[to]; ok {
+			break
+		}
+
+		cur := queue[0]
+		queue = queue[1:]
+
+		for _, next := range m.links[cur] {
+			if _, seen := prev[next]; seen {
+				continue
+			}
+
+			hop, ok := m.hop(cur, next, t, avoid)
+			if !ok {
+				continue
+			}
+
+			prev[next] = hop
+			queue = append(queue, next)
+		}
+	}
+
+	var route []Hop
+	if _, ok := prev[to]; ok {
+		route = []Hop{}
+		for cur := to; cur != from; cur = prev[cur].From {
+			route = append([]Hop{prev[cur]}, route...)
+		}
+	}
+
+	if len(avoid) == 0 {
+		m.routes[cacheKey] = route
+	}
+	return route, route != nil
+}
+
+// hop returns the step from one asset to another through a pair that was listed at t and isn't avoided.
+func (m *Market) hop(from, to string, t time.Time, avoid map[string]bool) (Hop, bool) {
+	for _, candidate := range []struct {
+		assets   string
+		inverted bool
+	}{{from + "/" + to, false}, {to + "/" + from, true}} {
+		key, ok := m.byAssets[candidate.assets]
+		if !ok || avoid[key] {
+			continue
+		}
+
+		if l, ok := m.listings[key]; ok && !l.Contains(t) {
+			continue
+		}
+
+		return Hop{From: from, To: to, Pair: key, Inverted: candidate.inverted}, true
+	}
+
+	return Hop{}, false
+}
@@ -0,0 +1,172 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"github.com/f-taxes/kraken_import/fetcher"
+)
+
+// testMarket builds a small synthetic market:
+//
+//	AAA/ZEUR, BBB/ZEUR, AAA/CCC, CCC/BBB, XXBT/ZEUR, DDD/XXBT, EEE/FFF
+func testMarket() *Market {
+	assets := map[string]fetcher.AssetInfo{}
+	for _, key := range []string{"AAA", "BBB", "CCC", "DDD", "EEE", "FFF", "ZEUR", "XXBT"} {
+		assets[key] = fetcher.AssetInfo{Altname: key}
+	}
+
+	pairs := map[string]fetcher.PairInfo{}
+	for _, p := range [][2]string{{"AAA", "ZEUR"}, {"BBB", "ZEUR"}, {"AAA", "CCC"}, {"CCC", "BBB"}, {"XXBT", "ZEUR"}, {"DDD", "XXBT"}, {"EEE", "FFF"}} {
+		pairs[p[0]+p[1]] = fetcher.PairInfo{Altname: p[0] + p[1], Base: p[0], Quote: p[1]}
+	}
+
+	return NewMarket(assets, pairs)
+}
+
+func TestRoute(t *testing.T) {
+	m := testMarket()
+
+	tests := []struct {
+		name     string
+		from, to string
+		want     []Hop
+		ok       bool
+	}{
+		{
+			name: "same asset",
+			from: "AAA", to: "AAA",
+			want: []Hop{}, ok: true,
+		},
+		{
+			name: "direct pair",
+			from: "AAA", to: "ZEUR",
+			want: []Hop{{From: "AAA", To: "ZEUR", Pair: "AAAZEUR"}}, ok: true,
+		},
+		{
+			name: "inverted pair",
+			from: "ZEUR", to: "AAA",
+			want: []Hop{{From: "ZEUR", To: "AAA", Pair: "AAAZEUR", Inverted: true}}, ok: true,
+		},
+		{
+			name: "multi hop",
+			from: "DDD", to: "AAA",
+			want: []Hop{
+				{From: "DDD", To: "XXBT", Pair: "DDDXXBT"},
+				{From: "XXBT", To: "ZEUR", Pair: "XXBTZEUR"},
+				{From: "ZEUR", To: "AAA", Pair: "AAAZEUR", Inverted: true},
+			},
+			ok: true,
+		},
+		{
+			// AAA -> CCC -> BBB is as short as AAA -> ZEUR -> BBB, but ZEUR is a hub.
+			name: "hub is preferred",
+			from: "AAA", to: "BBB",
+			want: []Hop{
+				{From: "AAA", To: "ZEUR", Pair: "AAAZEUR"},
+				{From: "ZEUR", To: "BBB", Pair: "BBBZEUR", Inverted: true},
+			},
+			ok: true,
+		},
+		{
+			name: "no route",
+			from: "AAA", to: "EEE",
+			want: nil, ok: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Twice, the second time from the route cache.
+			for i := 0; i < 2; i++ {
+				got, ok := m.Route(tt.from, tt.to, time.Now())
+				if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("Route(%s, %s) = %v, %t, want %v, %t", tt.from, tt.to, got, ok, tt.want, tt.ok)
+				}
+			}
+		})
+	}
+}
+
+func TestRouteListings(t *testing.T) {
+	listed := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	delisted := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// AAA/ZEUR was delisted, CCC/BBB listed later.
+	m := testMarket().WithListings(map[string]fetcher.Listing{
+		"AAAZEUR": {Until: delisted},
+		"CCCBBB":  {From: listed},
+	})
+
+	viaHub := []Hop{
+		{From: "AAA", To: "ZEUR", Pair: "AAAZEUR"},
+		{From: "ZEUR", To: "BBB", Pair: "BBBZEUR", Inverted: true},
+	}
+	viaCCC := []Hop{
+		{From: "AAA", To: "CCC", Pair: "AAACCC"},
+		{From: "CCC", To: "BBB", Pair: "CCCBBB"},
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want []Hop
+		ok   bool
+	}{
+		{name: "before the listing", at: listed.Add(-time.Hour), want: viaHub, ok: true},
+		{name: "while both are listed", at: listed.Add(time.Hour), want: viaHub, ok: true},
+		{name: "after the delisting", at: delisted.Add(time.Hour), want: viaCCC, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := m.Route("AAA", "BBB", tt.at)
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Route(AAA, BBB) at %s = %v, %t, want %v, %t", tt.at, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+
+	if _, ok := m.RouteAvoiding("AAA", "ZEUR", delisted.Add(time.Hour), map[string]bool{"BBBZEUR": true}); ok {
+		t.Errorf("found a route from AAA to ZEUR after the delisting without BBB/ZEUR")
+	}
+}
+
+func TestRateFallsBackToAnotherRoute(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// The preferred route through ZEUR has no price for AAA/ZEUR.
+	h := NewHistory(db)
+	for pair, price := range map[string]float64{"BBBZEUR": 3, "AAACCC": 2, "CCCBBB": 5} {
+		if err := h.Put(pair, 1, []Candle{{Start: at, Close: price}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	source := NewKrakenSource(nil, nil, h, nil)
+	source.Offline = true
+	c := NewConverter(testMarket(), source)
+
+	rate, by, err := c.Rate(context.Background(), "AAA", "BBB", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate.String() != "10" || by != "Kraken AAACCC (1m candle) → CCCBBB (1m candle)" {
+		t.Errorf("rate = %s by %q, want 10 through CCC", rate, by)
+	}
+
+	if _, _, err := c.Rate(context.Background(), "DDD", "ZEUR", at); !errors.Is(err, ErrNoPrice) {
+		t.Errorf("rate without any prices failed with %v, want ErrNoPrice", err)
+	}
+}
@@ -0,0 +1,216 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/f-taxes/kraken_import/krakenapi"
+	"github.com/kataras/golog"
+	"github.com/shopspring/decimal"
+	"go.uber.org/ratelimit"
+)
+
+var ErrNoPrice = errors.New("no price available")
+
+// Candle intervals in minutes. Kraken only returns the latest 720 candles of an interval,
+// so the smallest interval that still reaches back far enough is used.
+var candleIntervals = []int{1, 15, 60, 240, 1440, 10080}
+
+const candlesPerRequest = 720
+
+// tradesWindow is the time span after the requested time whose public trades are averaged.
+const tradesWindow = 5 * time.Minute
+
+// tradesTolerance is how far the first public trade may be away from the requested time if there is none within tradesWindow.
+const tradesTolerance = 24 * time.Hour
+
+// Quote is a price and a short description of where it came from.
+type Quote struct {
+	Price  decimal.Decimal
+	Source string // e.g. "1h candle" or "trades".
+}
+
+// KrakenSource looks up historical prices of Kraken pairs in the public OHLC and Trades data.
+// Candles are kept in the local price history, so once fetched (or imported) they are available offline.
+type KrakenSource struct {
+	api     *krakenapi.KrakenAPI
+	cache   *Cache
+	history *History
+	ticks   *TickStore
+	limiter ratelimit.Limiter
+
+	// Offline restricts lookups to the local price history.
+	Offline bool
+
+	// TickTolerance enables pricing at the public trade nearest to the requested time,
+	// as long as it is no further away than this. Candles are used otherwise.
+	TickTolerance time.Duration
+}
+
+func NewKrakenSource(api *krakenapi.KrakenAPI, cache *Cache, history *History, ticks *TickStore) *KrakenSource {
+	return &KrakenSource{
+		api:     api,
+		cache:   cache,
+		history: history,
+		ticks:   ticks,
+		limiter: ratelimit.New(1),
+	}
+}
+
+// History returns the local candle store of the source.
+func (s *KrakenSource) History() *History {
+	return s.history
+}
+
+// Price returns the price of a pair (by its key, e.g. XXBTZEUR) at time t.
+func (s *KrakenSource) Price(ctx context.Context, pair string, t time.Time) (Quote, error) {
+	t = t.UTC()
+	age := time.Since(t)
+
+	if s.TickTolerance > 0 {
+		q, ok, err := s.NearestTrade(ctx, pair, t, s.TickTolerance)
+		if err != nil {
+			golog.Warnf("Failed to load the public trades of %s around %s, using candles instead: %v", pair, t.Format(time.RFC3339), err)
+		} else if ok {
+			return q, nil
+		}
+	}
+
+	for _, interval := range candleIntervals {
+		if c, ok := s.history.Get(pair, interval, t); ok {
+			return Quote{Price: decimal.NewFromFloat(c.Price()), Source: candleSource(interval)}, nil
+		}
+	}
+
+	if s.Offline {
+		return Quote{}, fmt.Errorf("%w for %s at %s in the local price history", ErrNoPrice, pair, t.Format(time.RFC3339))
+	}
+
+	for _, interval := range candleIntervals {
+		span := time.Duration(interval*(candlesPerRequest-1)) * time.Minute
+
+		// Daily and weekly candles are too coarse as long as public trades can be used instead.
+		if interval >= 1440 {
+			break
+		}
+
+		if age < span {
+			return s.candlePrice(ctx, pair, interval, t)
+		}
+	}
+
+	q, err := s.tradesPrice(ctx, pair, t)
+	if err == nil {
+		return q, nil
+	}
+
+	for _, interval := range candleIntervals[4:] {
+		if age < time.Duration(interval*(candlesPerRequest-1))*time.Minute {
+			return s.candlePrice(ctx, pair, interval, t)
+		}
+	}
+
+	return Quote{}, err
+}
+
+func candleSource(interval int) string {
+	switch {
+	case interval < 60:
+		return fmt.Sprintf("%dm candle", interval)
+	case interval < 1440:
+		return fmt.Sprintf("%dh candle", interval/60)
+	case interval < 10080:
+		return "1d candle"
+	}
+
+	return "1w candle"
+}
+
+func (s *KrakenSource) candlePrice(ctx context.Context, pair string, interval int, t time.Time) (Quote, error) {
+	if _, err := s.fetchCandles(ctx, pair, interval); err != nil {
+		return Quote{}, err
+	}
+
+	c, ok := s.history.Get(pair, interval, t)
+	if !ok {
+		return Quote{}, fmt.Errorf("%w for %s at %s", ErrNoPrice, pair, t.Format(time.RFC3339))
+	}
+
+	return Quote{Price: decimal.NewFromFloat(c.Price()), Source: candleSource(interval)}, nil
+}
+
+// fetchCandles stores the latest candles of an interval that the api provides in the price history.
+func (s *KrakenSource) fetchCandles(ctx context.Context, pair string, interval int) (int, error) {
+	s.limiter.Take()
+	resp, err := s.api.OHLCWithInterval(ctx, pair, strconv.Itoa(interval))
+	if err != nil {
+		return 0, err
+	}
+
+	candles := make([]Candle, 0, len(resp.OHLC))
+
+	for _, c := range resp.OHLC {
+		// The last candle is still open and its price will change.
+		if c.Time.Add(time.Duration(interval) * time.Minute).After(time.Now()) {
+			continue
+		}
+
+		if c.Close == 0 {
+			continue
+		}
+
+		candles = append(candles, Candle{Start: c.Time.UTC(), Close: c.Close, Vwap: c.Vwap, Volume: c.Volume})
+	}
+
+	return len(candles), s.history.Put(pair, interval, candles)
+}
+
+func (s *KrakenSource) tradesPrice(ctx context.Context, pair string, t time.Time) (Quote, error) {
+	key := tradeKey(pair, t)
+
+	if p, ok := s.cache.get(key); ok {
+		return Quote{Price: p, Source: "trades"}, nil
+	}
+
+	s.limiter.Take()
+	resp, err := s.api.Trades(ctx, pair, t.Unix())
+	if err != nil {
+		return Quote{}, err
+	}
+
+	if len(resp.Trades) == 0 {
+		return Quote{}, fmt.Errorf("%w for %s at %s", ErrNoPrice, pair, t.Format(time.RFC3339))
+	}
+
+	first := resp.Trades[0]
+	if time.Unix(first.Time, 0).Sub(t) > tradesTolerance {
+		return Quote{}, fmt.Errorf("%w for %s at %s, the closest trade happened at %s", ErrNoPrice, pair, t.Format(time.RFC3339), time.Unix(first.Time, 0).UTC().Format(time.RFC3339))
+	}
+
+	volume := decimal.Zero
+	value := decimal.Zero
+
+	for _, tr := range resp.Trades {
+		if time.Unix(tr.Time, 0).Sub(t) > tradesWindow {
+			break
+		}
+
+		v := decimal.NewFromFloat(tr.VolumeFloat)
+		volume = volume.Add(v)
+		value = value.Add(v.Mul(decimal.NewFromFloat(tr.PriceFloat)))
+	}
+
+	price := decimal.NewFromFloat(first.PriceFloat)
+	if !volume.IsZero() {
+		price = value.Div(volume)
+	}
+
+	if err := s.cache.put(map[string]decimal.Decimal{key: price}); err != nil {
+		return Quote{}, err
+	}
+
+	return Quote{Price: price, Source: "trades"}, nil
+}
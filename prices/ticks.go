@@ -0,0 +1,314 @@
+package prices
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	ticksPrefix      = cachePrefix + "ticks:"
+	ticksIndexPrefix = cachePrefix + "ticksidx:"
+)
+
+// maxTickPages limits how many pages of public trades are loaded for a single lookup.
+// Very busy markets have thousands of trades per minute.
+const maxTickPages = 20
+
+// Tick is a single public trade.
+type Tick struct {
+	Time   time.Time
+	Price  float64
+	Volume float64
+}
+
+// TickStore caches public trades on disk in buckets of one minute. A bucket is only stored once
+// all trades of its minute are known, so an empty bucket means there were no trades.
+// The number of buckets is bounded, the ones written first are evicted first.
+type TickStore struct {
+	db  *badger.DB
+	max int
+
+	mu      sync.Mutex
+	count   int
+	counted bool
+}
+
+func NewTickStore(db *badger.DB, maxBuckets int) *TickStore {
+	return &TickStore{db: db, max: maxBuckets}
+}
+
+func tickBucketKey(pair string, minute time.Time) []byte {
+	return []byte(fmt.Sprintf("%s%s:%d", ticksPrefix, pair, minute.Unix()))
+}
+
+func encodeTicks(minute time.Time, ticks []Tick) []byte {
+	buf := make([]byte, 0, len(ticks)*(2+2*8))
+	for _, t := range ticks {
+		buf = binary.AppendUvarint(buf, uint64(t.Time.Sub(minute)))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(t.Price))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(t.Volume))
+	}
+	return buf
+}
+
+func decodeTicks(minute time.Time, buf []byte) ([]Tick, error) {
+	ticks := []Tick{}
+	for len(buf) > 0 {
+		offset, n := binary.Uvarint(buf)
+		if n <= 0 || len(buf) < n+16 {
+			return nil, errors.New("corrupt tick bucket")
+		}
+		buf = buf[n:]
+
+		ticks = append(ticks, Tick{
+			Time:   minute.Add(time.Duration(offset)),
+			Price:  math.Float64frombits(binary.LittleEndian.Uint64(buf[0:])),
+			Volume: math.Float64frombits(binary.LittleEndian.Uint64(buf[8:])),
+		})
+		buf = buf[16:]
+	}
+	return ticks, nil
+}
+
+// Covered returns the trades of a pair in [from, to) if all of them are cached.
+func (s *TickStore) Covered(pair string, from, to time.Time) ([]Tick, bool) {
+	ticks := []Tick{}
+	complete := true
+
+	s.db.View(func(txn *badger.Txn) error {
+		for m := from.Truncate(time.Minute); m.Before(to); m = m.Add(time.Minute) {
+			item, err := txn.Get(tickBucketKey(pair, m))
+			if err != nil {
+				complete = false
+				return nil
+			}
+
+			err = item.Value(func(val []byte) error {
+				bucket, err := decodeTicks(m, val)
+				ticks = append(ticks, bucket...)
+				return err
+			})
+			if err != nil {
+				complete = false
+				return nil
+			}
+		}
+		return nil
+	})
+
+	return ticks, complete
+}
+
+// Put caches the trades of a pair that are known to be complete in [from, to).
+// Only whole minutes inside the span are stored.
+func (s *TickStore) Put(pair string, from, to time.Time, ticks []Tick) error {
+	byMinute := map[int64][]Tick{}
+	for _, t := range ticks {
+		m := t.Time.Truncate(time.Minute).Unix()
+		byMinute[m] = append(byMinute[m], t)
+	}
+
+	start := from.Truncate(time.Minute)
+	if start.Before(from) {
+		start = start.Add(time.Minute)
+	}
+
+	written := 0
+	now := time.Now().UnixNano()
+
+	err := s.db.Update(func(txn *badger.Txn) error {
+		for m := start; !m.Add(time.Minute).After(to); m = m.Add(time.Minute) {
+			key := tickBucketKey(pair, m)
+
+			if _, err := txn.Get(key); err == nil {
+				continue
+			}
+
+			if err := txn.Set(key, encodeTicks(m, byMinute[m.Unix()])); err != nil {
+				return err
+			}
+
+			if err := txn.Set([]byte(fmt.Sprintf("%s%020d:%s", ticksIndexPrefix, now, key)), nil); err != nil {
+				return err
+			}
+
+			now++
+			written++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.evict(written)
+}
+
+// evict removes the oldest buckets once there are more than allowed.
+func (s *TickStore) evict(added int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.counted {
+		s.count = 0
+		s.db.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			opts.Prefix = []byte(ticksIndexPrefix)
+			it := txn.NewIterator(opts)
+			defer it.Close()
+
+			for it.Rewind(); it.Valid(); it.Next() {
+				s.count++
+			}
+			return nil
+		})
+		s.counted = true
+	} else {
+		s.count += added
+	}
+
+	if s.max <= 0 || s.count <= s.max {
+		return nil
+	}
+
+	excess := s.count - s.max
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(ticksIndexPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid() && excess > 0; it.Next() {
+			idx := it.Item().KeyCopy(nil)
+			bucket := idx[len(ticksIndexPrefix)+21:]
+
+			if err := wb.Delete(idx); err != nil {
+				return err
+			}
+			if err := wb.Delete(bucket); err != nil {
+				return err
+			}
+
+			excess--
+			s.count--
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return wb.Flush()
+}
+
+// NearestTrade returns the price of the public trade closest to t, if there is one within tolerance.
+func (s *KrakenSource) NearestTrade(ctx context.Context, pair string, t time.Time, tolerance time.Duration) (Quote, bool, error) {
+	from, to := t.Add(-tolerance).Truncate(time.Minute), t.Add(tolerance)
+
+	ticks, ok := s.ticks.Covered(pair, from, to)
+	if !ok {
+		if s.Offline {
+			return Quote{}, false, nil
+		}
+
+		var err error
+		if ticks, err = s.loadTicks(ctx, pair, from, to); err != nil {
+			return Quote{}, false, err
+		}
+	}
+
+	var nearest *Tick
+	var distance time.Duration
+
+	for i := range ticks {
+		d := ticks[i].Time.Sub(t).Abs()
+		if d <= tolerance && (nearest == nil || d < distance) {
+			nearest = &ticks[i]
+			distance = d
+		}
+	}
+
+	if nearest == nil {
+		return Quote{}, false, nil
+	}
+
+	return Quote{Price: decimal.NewFromFloat(nearest.Price), Source: fmt.Sprintf("trade %s off", distance)}, true, nil
+}
+
+// loadTicks pages through the public trades of a pair from "from" until "to" and caches them.
+func (s *KrakenSource) loadTicks(ctx context.Context, pair string, from, to time.Time) ([]Tick, error) {
+	since := from.UnixNano()
+	ticks := []Tick{}
+
+	for page := 0; page < maxTickPages; page++ {
+		s.limiter.Take()
+		resp, err := s.api.Trades(ctx, pair, since)
+		if err != nil {
+			return nil, err
+		}
+
+		pageStart := time.Unix(0, since)
+		pageTicks := make([]Tick, 0, len(resp.Trades))
+		for _, tr := range resp.Trades {
+			pageTicks = append(pageTicks, Tick{Time: time.Unix(tr.Time, 0), Price: tr.PriceFloat, Volume: tr.VolumeFloat})
+		}
+		ticks = append(ticks, pageTicks...)
+
+		if len(pageTicks) == 0 {
+			return ticks, nil
+		}
+
+		// Trades of the last second may continue on the next page, so the page is only complete up to there.
+		// Only the minutes of the lookup are stored, a page of an illiquid pair can span weeks of empty minutes.
+		last := pageTicks[len(pageTicks)-1].Time
+		if start, end := later(pageStart, from), earlier(last, ceilMinute(to)); start.Before(end) {
+			if err := s.ticks.Put(pair, start, end, pageTicks); err != nil {
+				return nil, err
+			}
+		}
+
+		if last.After(to) || resp.Last == 0 {
+			return ticks, nil
+		}
+
+		since = resp.Last
+	}
+
+	return ticks, nil
+}
+
+// ceilMinute rounds t up to the next full minute.
+func ceilMinute(t time.Time) time.Time {
+	m := t.Truncate(time.Minute)
+	if m.Before(t) {
+		m = m.Add(time.Minute)
+	}
+	return m
+}
+
+func earlier(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,72 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/f-taxes/kraken_import/krakenapi"
+)
+
+func TestNearestTradeStoresOnlyTheLookup(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)
+	later := at.Add(14 * 24 * time.Hour)
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		// An illiquid pair: the page reaches two weeks past the lookup.
+		fmt.Fprintf(w, `{"error": [], "result": {"XYZEUR": [
+			["1.10", "5", %d, "b", "l", ""],
+			["1.20", "5", %d, "s", "l", ""],
+			["1.30", "5", %d, "b", "l", ""]
+		], "last": "%d"}}`, at.Add(-20*time.Second).Unix(), at.Add(10*time.Second).Unix(), later.Unix(), later.UnixNano())
+	}))
+	t.Cleanup(srv.Close)
+
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	s := NewKrakenSource(krakenapi.New("", "").WithBaseURL(srv.URL), nil, nil, NewTickStore(db, 1000))
+
+	for i := 0; i < 2; i++ {
+		q, ok, err := s.NearestTrade(context.Background(), "XYZEUR", at, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || q.Price.String() != "1.2" {
+			t.Fatalf("nearest trade = %v, %t, want 1.2", q, ok)
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("loaded trades %d times, want once and then from the cache", requests)
+	}
+
+	buckets := 0
+	db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(ticksPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			buckets++
+		}
+		return nil
+	})
+
+	// 11:59 to 12:01, the minutes of the lookup.
+	if buckets != 3 {
+		t.Errorf("stored %d buckets, want 3", buckets)
+	}
+}
@@ -0,0 +1,203 @@
+package reconcile
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/f-taxes/kraken_import/fetcher"
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/grpc_client"
+	"github.com/f-taxes/kraken_import/outbox"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/f-taxes/kraken_import/sink"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Entry identifies a record in a report.
+type Entry struct {
+	Kind   string    `json:"kind"` // "trade" or "transfer".
+	TxID   string    `json:"txId"`
+	Ts     time.Time `json:"ts"`
+	Action string    `json:"action"`
+	Asset  string    `json:"asset"`
+	Amount string    `json:"amount"`
+}
+
+// Report is the result of comparing the records of an account with the ones the core holds.
+type Report struct {
+	Account     string    `json:"account"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	Local       int       `json:"local"`       // Number of records built from Kraken's data.
+	Core        int       `json:"core"`        // Number of records the core holds for the account.
+	Missing     []Entry   `json:"missing"`     // Known to Kraken but not to the core.
+	Extra       []Entry   `json:"extra"`       // Held by the core but unknown to Kraken.
+	Resubmitted int       `json:"resubmitted"` // Number of missing records that have been queued again.
+}
+
+// Run compares the records of an account within the window with the records the core holds.
+// A zero Since starts at the beginning of the account's history, a zero Until ends at the account's last fetch.
+// If resubmit is set, missing records are queued in the outbox. Records the core has in excess are only reported,
+// as they may have been added or edited by the user.
+func Run(ctx context.Context, ob *outbox.Outbox, acc g.Account, w fetcher.Window, resubmit bool) (*Report, error) {
+	from := w.Since
+	if from.IsZero() {
+		from = time.Unix(0, 0).UTC()
+	}
+
+	to := w.Until
+	if to.IsZero() {
+		to, _ = time.Parse(time.RFC3339Nano, acc.LastFetched)
+		if to.IsZero() {
+			to = time.Now().UTC()
+		}
+	}
+
+	// Records that are still queued aren't missing, they just haven't arrived yet.
+	if err := ob.Flush(ctx, acc.ID); err != nil {
+		return nil, err
+	}
+
+	local := &collector{from: from, to: to, recs: map[string]*proto.Record{}}
+
+	f, err := fetcher.New(ctx, acc, local)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.Fetch(ctx, from, to); err != nil {
+		return nil, err
+	}
+
+	core := map[string]*proto.Record{}
+
+	err = grpc_client.GrpcClient.StreamRecords(ctx, &proto.StreamRecordsJob{From: timestamppb.New(from), To: timestamppb.New(to)}, func(rec *proto.Record) error {
+		if account(rec) == acc.Label {
+			core[key(rec)] = rec
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Account: acc.Label,
+		From:    from,
+		To:      to,
+		Local:   len(local.recs),
+		Core:    len(core),
+		Missing: []Entry{},
+		Extra:   []Entry{},
+	}
+
+	missing := []*proto.Record{}
+
+	for k, rec := range local.recs {
+		if _, ok := core[k]; !ok {
+			missing = append(missing, rec)
+		}
+	}
+
+	for k, rec := range core {
+		if _, ok := local.recs[k]; !ok {
+			report.Extra = append(report.Extra, entry(rec))
+		}
+	}
+
+	sort.Slice(missing, func(i, j int) bool {
+		return ts(missing[i]).Before(ts(missing[j]))
+	})
+
+	for _, rec := range missing {
+		report.Missing = append(report.Missing, entry(rec))
+	}
+
+	sort.Slice(report.Extra, func(i, j int) bool {
+		return report.Extra[i].Ts.Before(report.Extra[j].Ts)
+	})
+
+	if !resubmit || len(missing) == 0 {
+		return report, nil
+	}
+
+	// The window of the resubmitted records has been fetched before, so the account's fetch time stays as it is.
+	batch, err := ob.Begin(acc.ID, time.Time{})
+	if err != nil {
+		return report, err
+	}
+
+	for _, rec := range missing {
+		if err := sink.Submit(ctx, batch, rec); err != nil {
+			batch.Abort()
+			return report, err
+		}
+	}
+
+	report.Resubmitted = len(missing)
+	return report, batch.Commit(ctx)
+}
+
+// collector keeps the records built by the fetcher in memory. Records outside of the time range are dropped,
+// as the fetcher may return more than was asked for.
+type collector struct {
+	mu   sync.Mutex
+	from time.Time
+	to   time.Time
+	recs map[string]*proto.Record
+}
+
+func (c *collector) SubmitTrade(ctx context.Context, t *proto.Trade) error {
+	return c.add(&proto.Record{Trade: t})
+}
+
+func (c *collector) SubmitTransfer(ctx context.Context, t *proto.Transfer) error {
+	return c.add(&proto.Record{Transfer: t})
+}
+
+func (c *collector) add(rec *proto.Record) error {
+	if t := ts(rec); t.Before(c.from) || t.After(c.to) {
+		return nil
+	}
+
+	c.mu.Lock()
+	c.recs[key(rec)] = rec
+	c.mu.Unlock()
+	return nil
+}
+
+// key identifies a record. Trades and transfers are kept apart as their ids come from different ledgers.
+func key(rec *proto.Record) string {
+	if rec.Trade != nil {
+		return "trade:" + rec.Trade.TxID
+	}
+
+	return "transfer:" + rec.Transfer.TxID
+}
+
+func account(rec *proto.Record) string {
+	if rec.Trade != nil {
+		return rec.Trade.Account
+	}
+
+	return rec.Transfer.Account
+}
+
+func ts(rec *proto.Record) time.Time {
+	if rec.Trade != nil {
+		return rec.Trade.Ts.AsTime()
+	}
+
+	return rec.Transfer.Ts.AsTime()
+}
+
+func entry(rec *proto.Record) Entry {
+	if t := rec.Trade; t != nil {
+		return Entry{Kind: "trade", TxID: t.TxID, Ts: t.Ts.AsTime(), Action: t.Action.String(), Asset: t.Asset, Amount: t.Amount}
+	}
+
+	t := rec.Transfer
+	return Entry{Kind: "transfer", TxID: t.TxID, Ts: t.Ts.AsTime(), Action: t.Action.String(), Asset: t.Asset, Amount: t.Amount}
+}
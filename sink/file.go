@@ -0,0 +1,128 @@
+package sink
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type Format int
+
+const (
+	FormatJSONL Format = iota // One proto.Record as json per line.
+	FormatCSV                 // One row per record, trades and transfers share the same columns.
+)
+
+var csvHeader = []string{
+	"kind", "txId", "ts", "account", "action", "orderType", "orderId", "asset", "quote", "amount", "price", "value",
+	"fee", "feeCurrency", "quoteFee", "quoteFeeCurrency", "source", "destination", "comment",
+}
+
+// File appends records to a file so the plugin can be used without an f-taxes core.
+type File struct {
+	mu     sync.Mutex
+	f      *os.File
+	format Format
+	csv    *csv.Writer
+}
+
+// NewFile opens (or creates) the file at path. A csv header is written if the file is empty.
+func NewFile(path string, format Format) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &File{f: f, format: format}
+
+	if format == FormatCSV {
+		s.csv = csv.NewWriter(f)
+
+		if info, err := f.Stat(); err == nil && info.Size() == 0 {
+			s.csv.Write(csvHeader)
+			s.csv.Flush()
+		}
+	}
+
+	return s, nil
+}
+
+func (s *File) SubmitTrade(ctx context.Context, t *proto.Trade) error {
+	t.Plugin = global.Plugin.ID
+	t.PluginVersion = global.Plugin.Version
+	t.Created = timestamppb.Now()
+
+	if s.format == FormatCSV {
+		return s.writeRow([]string{
+			"trade", t.TxID, formatTs(t.Ts), t.Account, t.Action.String(), t.OrderType.String(), t.OrderID, t.Asset, t.Quote,
+			t.Amount, t.Price, t.Value, t.Fee, t.FeeCurrency, t.QuoteFee, t.QuoteFeeCurrency, "", "", t.Comment,
+		})
+	}
+
+	return s.writeJSON(&proto.Record{Trade: t})
+}
+
+func (s *File) SubmitTransfer(ctx context.Context, t *proto.Transfer) error {
+	t.Plugin = global.Plugin.ID
+	t.PluginVersion = global.Plugin.Version
+	t.Created = timestamppb.Now()
+
+	if s.format == FormatCSV {
+		return s.writeRow([]string{
+			"transfer", t.TxID, formatTs(t.Ts), t.Account, t.Action.String(), "", "", t.Asset, "",
+			t.Amount, "", "", t.Fee, t.FeeCurrency, "", "", t.Source, t.Destination, t.Comment,
+		})
+	}
+
+	return s.writeJSON(&proto.Record{Transfer: t})
+}
+
+func (s *File) writeJSON(rec *proto.Record) error {
+	data, err := protojson.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.f.Write(append(data, '\n'))
+	return err
+}
+
+func (s *File) writeRow(row []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.csv.Write(row)
+	s.csv.Flush()
+	return s.csv.Error()
+}
+
+// Close closes the underlying file.
+func (s *File) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.f.Close()
+}
+
+func formatTs(ts *timestamppb.Timestamp) string {
+	if ts == nil {
+		return ""
+	}
+
+	return ts.AsTime().UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,50 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/f-taxes/kraken_import/grpc_client"
+	"github.com/f-taxes/kraken_import/proto"
+)
+
+// Sink receives the records the fetcher produces.
+type Sink interface {
+	SubmitTrade(ctx context.Context, t *proto.Trade) error
+	SubmitTransfer(ctx context.Context, t *proto.Transfer) error
+}
+
+// Grpc submits records to the f-taxes core.
+type Grpc struct{}
+
+func (Grpc) SubmitTrade(ctx context.Context, t *proto.Trade) error {
+	return grpc_client.GrpcClient.SubmitTrade(ctx, t)
+}
+
+func (Grpc) SubmitTransfer(ctx context.Context, t *proto.Transfer) error {
+	return grpc_client.GrpcClient.SubmitTransfer(ctx, t)
+}
+
+// Submit hands a record to the sink.
+func Submit(ctx context.Context, s Sink, rec *proto.Record) error {
+	if rec.Trade != nil {
+		return s.SubmitTrade(ctx, rec.Trade)
+	}
+
+	return s.SubmitTransfer(ctx, rec.Transfer)
+}
+
+// Open returns the sink selected by kind: "grpc", "jsonl" or "csv". File sinks write to path.
+func Open(kind, path string) (Sink, error) {
+	switch strings.ToLower(kind) {
+	case "", "grpc":
+		return Grpc{}, nil
+	case "jsonl":
+		return NewFile(path, FormatJSONL)
+	case "csv":
+		return NewFile(path, FormatCSV)
+	}
+
+	return nil, fmt.Errorf("unknown sink \"%s\", use grpc, jsonl or csv", kind)
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// DB is the local key value store of the plugin. Packages keep their data under their own key prefix.
+var DB *badger.DB
+
+// ErrNotFound is returned by GetJSON if the key doesn't exist.
+var ErrNotFound = badger.ErrKeyNotFound
+
+// Open opens (or creates) the database in the given folder.
+func Open(path string) error {
+	db, err := badger.Open(badger.DefaultOptions(path).WithLoggingLevel(badger.WARNING))
+	if err != nil {
+		return err
+	}
+
+	DB = db
+	return nil
+}
+
+// Close flushes and closes the database.
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+
+	return DB.Close()
+}
+
+// PutJSON stores v as json under key.
+func PutJSON(txn *badger.Txn, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return txn.Set([]byte(key), data)
+}
+
+// GetJSON reads the json stored under key into v.
+func GetJSON(txn *badger.Txn, key string, v any) error {
+	item, err := txn.Get([]byte(key))
+	if err != nil {
+		return err
+	}
+
+	return item.Value(func(val []byte) error {
+		return json.Unmarshal(val, v)
+	})
+}
+
+// Keys returns all keys with the given prefix in ascending order.
+func Keys(txn *badger.Txn, prefix string) []string {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = []byte(prefix)
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	keys := []string{}
+	for it.Rewind(); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Item().KeyCopy(nil)))
+	}
+
+	return keys
+}
+
+// IsNotFound returns true if err signals a missing key.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
@@ -0,0 +1,217 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/f-taxes/kraken_import/conf"
+	g "github.com/f-taxes/kraken_import/global"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PassphraseEnv is the environment variable that holds the passphrase if the vault is protected by one.
+const PassphraseEnv = "KRAKEN_IMPORT_PASSPHRASE"
+
+const (
+	prefix     = "enc:v1:"
+	checkPlain = "kraken_import"
+)
+
+var (
+	ErrLocked   = errors.New("the credential vault is locked")
+	ErrWrongKey = errors.New("the passphrase or key file doesn't match the one the credentials were encrypted with")
+)
+
+var key []byte
+
+// Init unlocks the vault that encrypts the credentials stored in the app config.
+// If the passphrase environment variable is set when the vault is initialized for the first time, the encryption key
+// is derived from that passphrase and the passphrase is required on every following start.
+// Otherwise a random key is generated and stored in the key file (secrets.keyFile, defaults to ./secret.key).
+func Init() error {
+	mode := conf.App.String("secrets.mode")
+	passphrase := os.Getenv(PassphraseEnv)
+
+	if mode == "" {
+		mode = "keyfile"
+		if passphrase != "" {
+			mode = "passphrase"
+		}
+		conf.App.Set("secrets.mode", mode)
+	}
+
+	var err error
+
+	switch mode {
+	case "passphrase":
+		if passphrase == "" {
+			return fmt.Errorf("credentials are protected by a passphrase, please provide it in the environment variable %s", PassphraseEnv)
+		}
+		key, err = deriveKey(passphrase)
+	case "keyfile":
+		key, err = loadOrCreateKeyFile(conf.App.String("secrets.keyFile", "./secret.key"))
+	default:
+		return fmt.Errorf("unknown secrets mode \"%s\"", mode)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// The check value detects a wrong passphrase or key file before any credential is decrypted with it.
+	check := conf.App.String("secrets.check")
+	if check == "" {
+		check, err = Encrypt(checkPlain)
+		if err != nil {
+			return err
+		}
+		conf.App.Set("secrets.check", check)
+		conf.WriteAppConfig()
+		return nil
+	}
+
+	if plain, err := Decrypt(check); err != nil || plain != checkPlain {
+		key = nil
+		return ErrWrongKey
+	}
+
+	return nil
+}
+
+func deriveKey(passphrase string) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(conf.App.String("secrets.salt"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(salt) == 0 {
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		conf.App.Set("secrets.salt", base64.StdEncoding.EncodeToString(salt))
+	}
+
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+func loadOrCreateKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	k := make([]byte, 32)
+	if _, err := rand.Read(k); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	return k, os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(k)), 0600)
+}
+
+// IsEncrypted returns true if the value has been encrypted by the vault.
+func IsEncrypted(v string) bool {
+	return strings.HasPrefix(v, prefix)
+}
+
+// Encrypt encrypts a value with AES-GCM. Empty values stay empty.
+func Encrypt(plain string) (string, error) {
+	if plain == "" || IsEncrypted(plain) {
+		return plain, nil
+	}
+
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt. Values that aren't encrypted are returned as they are.
+func Decrypt(v string) (string, error) {
+	if !IsEncrypted(v) {
+		return v, nil
+	}
+
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(v, prefix))
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", ErrWrongKey
+	}
+
+	return string(plain), nil
+}
+
+func newGCM() (cipher.AEAD, error) {
+	if key == nil {
+		return nil, ErrLocked
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// SealAccount returns a copy of the account with all credentials encrypted.
+func SealAccount(acc g.Account) (g.Account, error) {
+	var err error
+
+	for _, field := range []*string{&acc.ApiKey, &acc.ApiSecret, &acc.Otp} {
+		if *field, err = Encrypt(*field); err != nil {
+			return acc, err
+		}
+	}
+
+	return acc, nil
+}
+
+// OpenAccount returns a copy of the account with all credentials decrypted.
+func OpenAccount(acc g.Account) (g.Account, error) {
+	var err error
+
+	for _, field := range []*string{&acc.ApiKey, &acc.ApiSecret, &acc.Otp} {
+		if *field, err = Decrypt(*field); err != nil {
+			return acc, err
+		}
+	}
+
+	return acc, nil
+}
@@ -0,0 +1,277 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/f-taxes/kraken_import/accounts"
+	"github.com/f-taxes/kraken_import/fetcher"
+	g "github.com/f-taxes/kraken_import/global"
+	"github.com/f-taxes/kraken_import/grpc_client"
+	iu "github.com/f-taxes/kraken_import/irisutils"
+	"github.com/f-taxes/kraken_import/krakenapi"
+	"github.com/f-taxes/kraken_import/outbox"
+	"github.com/f-taxes/kraken_import/proto"
+	"github.com/f-taxes/kraken_import/reconcile"
+	"github.com/kataras/golog"
+	"github.com/kataras/iris/v12"
+)
+
+func registerAccountRoutes(app *iris.Application) {
+	app.Get("/account/list", func(ctx iris.Context) {
+		list, err := accounts.Repo.List()
+		if err != nil {
+			golog.Errorf("Failed to load accounts: %v", err)
+			ctx.JSON(iu.Resp{
+				Result: false,
+			})
+			return
+		}
+
+		for i := range list {
+			list[i] = list[i].Masked()
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+			Data:   list,
+		})
+	})
+
+	app.Post("/account/validate", func(ctx iris.Context) {
+		reqData := g.Account{}
+
+		if !iu.ReadJSON(ctx, &reqData) {
+			return
+		}
+
+		probe := fetcher.ProbeKey(context.Background(), reqData)
+
+		ctx.JSON(iu.Resp{
+			Result: probe.Valid && !probe.MissingRequired(),
+			Data:   probe,
+		})
+	})
+
+	app.Post("/account/add", func(ctx iris.Context) {
+		reqData := struct {
+			g.Account
+			Force bool `json:"force"` // Save the account even if required permissions are missing.
+		}{}
+
+		if !iu.ReadJSON(ctx, &reqData) {
+			return
+		}
+
+		if !validateAccount(ctx, reqData.Account, reqData.Force) {
+			return
+		}
+
+		acc, err := accounts.Repo.Add(reqData.Account)
+		if err != nil {
+			golog.Errorf("Failed to add account %s: %v", reqData.Label, err)
+			ctx.JSON(iu.Resp{
+				Result: false,
+			})
+			return
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+			Data:   acc.Masked(),
+		})
+	})
+
+	app.Post("/account/update", func(ctx iris.Context) {
+		reqData := struct {
+			g.Account
+			Force bool `json:"force"` // Save the account even if required permissions are missing.
+		}{}
+
+		if !iu.ReadJSON(ctx, &reqData) {
+			return
+		}
+
+		stored, err := accounts.Repo.Get(reqData.ID)
+		if err != nil {
+			golog.Errorf("Failed to load account %s: %v", reqData.ID, err)
+			ctx.JSON(iu.Resp{
+				Result: false,
+			})
+			return
+		}
+
+		acc := reqData.Account
+
+		// The fetch cursor isn't editable and must survive a key rotation.
+		acc.LastFetched = stored.LastFetched
+
+		// The ui only knows the masked credentials. Masked or empty values keep what is stored.
+		if acc.ApiKey == "" || acc.ApiKey == stored.Masked().ApiKey {
+			acc.ApiKey = stored.ApiKey
+		}
+
+		if acc.ApiSecret == "" {
+			acc.ApiSecret = stored.ApiSecret
+		}
+
+		if acc.Otp == "" && acc.OtpType == stored.OtpType {
+			acc.Otp = stored.Otp
+		}
+
+		credentialsChanged := acc.ApiKey != stored.ApiKey || acc.ApiSecret != stored.ApiSecret || acc.OtpType != stored.OtpType || acc.Otp != stored.Otp
+
+		if credentialsChanged && !validateAccount(ctx, acc, reqData.Force) {
+			return
+		}
+
+		updated, err := accounts.Repo.Update(acc)
+		if err != nil {
+			golog.Errorf("Failed to update account %s: %v", acc.Label, err)
+
+			msg := ""
+			if errors.Is(err, accounts.ErrConflict) {
+				msg = "The account has been changed in the meantime. Please reload and try again."
+			}
+
+			ctx.JSON(iu.Resp{
+				Result: false,
+				Data:   msg,
+			})
+			return
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+			Data:   updated.Masked(),
+		})
+	})
+
+	app.Post("/account/remove", func(ctx iris.Context) {
+		reqData := struct {
+			ID string `json:"id"`
+		}{}
+
+		if !iu.ReadJSON(ctx, &reqData) {
+			return
+		}
+
+		if err := accounts.Repo.Remove(reqData.ID); err != nil {
+			golog.Errorf("Failed to remove account %s: %v", reqData.ID, err)
+			ctx.JSON(iu.Resp{
+				Result: false,
+			})
+			return
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+		})
+	})
+
+	app.Post("/account/fetch/one", func(ctx iris.Context) {
+		reqData := struct {
+			ID    string    `json:"id"`
+			Since time.Time `json:"since"`
+		}{}
+
+		if !iu.ReadJSON(ctx, &reqData) {
+			return
+		}
+
+		acc, err := accounts.Repo.Get(reqData.ID)
+		if err != nil {
+			golog.Errorf("No account with id %s found.", reqData.ID)
+			ctx.JSON(iu.Resp{
+				Result: false,
+			})
+			return
+		}
+
+		err = fetcher.Run(context.Background(), outbox.Default, acc, fetcher.Window{})
+		if errors.Is(err, outbox.ErrQueued) {
+			golog.Warnf("Records of account %s are queued but couldn't be delivered yet: %v", acc.Label, err)
+			ctx.JSON(iu.Resp{
+				Result: false,
+				Data:   outbox.ErrQueued.Error(),
+			})
+			return
+		}
+
+		if err != nil {
+			fetchFailed(ctx, acc, err)
+			return
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+		})
+	})
+
+	app.Post("/account/reconcile", func(ctx iris.Context) {
+		reqData := struct {
+			ID         string    `json:"id"`
+			Since      time.Time `json:"since"`
+			Until      time.Time `json:"until"`
+			ReportOnly bool      `json:"reportOnly"`
+		}{}
+
+		if !iu.ReadJSON(ctx, &reqData) {
+			return
+		}
+
+		acc, err := accounts.Repo.Get(reqData.ID)
+		if err != nil {
+			golog.Errorf("No account with id %s found.", reqData.ID)
+			ctx.JSON(iu.Resp{
+				Result: false,
+			})
+			return
+		}
+
+		report, err := reconcile.Run(context.Background(), outbox.Default, acc, fetcher.Window{Since: reqData.Since, Until: reqData.Until}, !reqData.ReportOnly)
+		if err != nil && !errors.Is(err, outbox.ErrQueued) {
+			fetchFailed(ctx, acc, err)
+			return
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+			Data:   report,
+		})
+	})
+}
+
+// validateAccount probes the API key of the account before it is saved. Accounts with an invalid key are refused.
+// Accounts that lack required permissions are refused unless force is set. In both cases the probe result is
+// sent to the client and false is returned.
+func validateAccount(ctx iris.Context, acc g.Account, force bool) bool {
+	probe := fetcher.ProbeKey(context.Background(), acc)
+
+	if !probe.Valid || (probe.MissingRequired() && !force) {
+		golog.Infof("Refused to save account %s. Key valid: %t, missing required permissions: %t", acc.Label, probe.Valid, probe.MissingRequired())
+		ctx.JSON(iu.Resp{
+			Result: false,
+			Data:   probe,
+		})
+		return false
+	}
+
+	return true
+}
+
+// fetchFailed reports a failed fetch to the f-taxes log and answers the request with a message
+// that tells the user how to resolve the problem.
+func fetchFailed(ctx iris.Context, acc g.Account, err error) {
+	golog.Errorf("Fetching account %s failed: %v", acc.Label, err)
+	msg := krakenapi.Guidance(err)
+
+	grpc_client.GrpcClient.AppLog(context.Background(), &proto.AppLogMsg{Level: proto.LogLevel_ERR, Message: fmt.Sprintf("[%s] Fetching account \"%s\" failed: %s", g.Plugin.Label, acc.Label, msg)})
+
+	ctx.JSON(iu.Resp{
+		Result: false,
+		Data:   msg,
+	})
+}
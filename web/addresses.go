@@ -0,0 +1,97 @@
+package web
+
+import (
+	"context"
+
+	"github.com/f-taxes/kraken_import/accounts"
+	"github.com/f-taxes/kraken_import/fetcher"
+	iu "github.com/f-taxes/kraken_import/irisutils"
+	"github.com/f-taxes/kraken_import/krakenapi"
+	"github.com/kataras/golog"
+	"github.com/kataras/iris/v12"
+)
+
+func registerAddressRoutes(app *iris.Application) {
+	app.Post("/account/addresses", func(ctx iris.Context) {
+		reqData := struct {
+			ID string `json:"id"`
+		}{}
+
+		if !iu.ReadJSON(ctx, &reqData) {
+			return
+		}
+
+		book, err := fetcher.AddressBook(reqData.ID)
+		if err != nil {
+			golog.Errorf("Failed to load the address book of account %s: %v", reqData.ID, err)
+			ctx.JSON(iu.Resp{
+				Result: false,
+			})
+			return
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+			Data:   book,
+		})
+	})
+
+	app.Post("/account/addresses/sync", func(ctx iris.Context) {
+		reqData := struct {
+			ID string `json:"id"`
+		}{}
+
+		if !iu.ReadJSON(ctx, &reqData) {
+			return
+		}
+
+		acc, err := accounts.Repo.Get(reqData.ID)
+		if err != nil {
+			golog.Errorf("No account with id %s found.", reqData.ID)
+			ctx.JSON(iu.Resp{
+				Result: false,
+			})
+			return
+		}
+
+		book, err := fetcher.SyncAddressBook(context.Background(), acc)
+		if err != nil {
+			golog.Errorf("Failed to load the address book of account %s: %v", acc.Label, err)
+			ctx.JSON(iu.Resp{
+				Result: false,
+				Data:   krakenapi.Guidance(err),
+			})
+			return
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+			Data:   book,
+		})
+	})
+
+	app.Post("/account/addresses/save", func(ctx iris.Context) {
+		reqData := struct {
+			ID     string                 `json:"id"`
+			Labels []fetcher.AddressLabel `json:"labels"`
+		}{}
+
+		if !iu.ReadJSON(ctx, &reqData) {
+			return
+		}
+
+		book, err := fetcher.SetAddressLabels(reqData.ID, reqData.Labels)
+		if err != nil {
+			golog.Errorf("Failed to save the address labels of account %s: %v", reqData.ID, err)
+			ctx.JSON(iu.Resp{
+				Result: false,
+			})
+			return
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+			Data:   book,
+		})
+	})
+}
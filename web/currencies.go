@@ -0,0 +1,45 @@
+package web
+
+import (
+	"github.com/f-taxes/kraken_import/fetcher"
+	iu "github.com/f-taxes/kraken_import/irisutils"
+	"github.com/kataras/golog"
+	"github.com/kataras/iris/v12"
+)
+
+func registerCurrencyRoutes(app *iris.Application) {
+	app.Get("/currencies/overrides", func(ctx iris.Context) {
+		overrides := fetcher.CurrencyOverrides()
+		if overrides == nil {
+			overrides = map[string]string{}
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+			Data:   overrides,
+		})
+	})
+
+	app.Post("/currencies/overrides/save", func(ctx iris.Context) {
+		reqData := struct {
+			Overrides map[string]string `json:"overrides"`
+		}{}
+
+		if !iu.ReadJSON(ctx, &reqData) {
+			return
+		}
+
+		if err := fetcher.SetCurrencyOverrides(reqData.Overrides); err != nil {
+			golog.Errorf("Failed to save currency overrides: %v", err)
+			ctx.JSON(iu.Resp{
+				Result: false,
+			})
+			return
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+			Data:   fetcher.CurrencyOverrides(),
+		})
+	})
+}
@@ -0,0 +1,142 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/f-taxes/kraken_import/accounts"
+	"github.com/f-taxes/kraken_import/fetcher"
+	iu "github.com/f-taxes/kraken_import/irisutils"
+	"github.com/f-taxes/kraken_import/outbox"
+	"github.com/f-taxes/kraken_import/preview"
+	"github.com/kataras/golog"
+	"github.com/kataras/iris/v12"
+)
+
+func registerPreviewRoutes(app *iris.Application) {
+	app.Post("/preview/fetch", func(ctx iris.Context) {
+		reqData := struct {
+			ID    string    `json:"id"`
+			Since time.Time `json:"since"`
+			Until time.Time `json:"until"`
+		}{}
+
+		if !iu.ReadJSON(ctx, &reqData) {
+			return
+		}
+
+		acc, err := accounts.Repo.Get(reqData.ID)
+		if err != nil {
+			golog.Errorf("No account with id %s found.", reqData.ID)
+			ctx.JSON(iu.Resp{
+				Result: false,
+			})
+			return
+		}
+
+		b, err := fetcher.Preview(context.Background(), preview.Default, acc, fetcher.Window{Since: reqData.Since, Until: reqData.Until})
+		if err != nil {
+			fetchFailed(ctx, acc, err)
+			return
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+			Data:   b,
+		})
+	})
+
+	app.Get("/preview/list", func(ctx iris.Context) {
+		list, err := preview.Default.List()
+		if err != nil {
+			golog.Errorf("Failed to load previews: %v", err)
+			ctx.JSON(iu.Resp{
+				Result: false,
+			})
+			return
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+			Data:   list,
+		})
+	})
+
+	app.Post("/preview/records", func(ctx iris.Context) {
+		reqData := struct {
+			ID string `json:"id"`
+			preview.Filter
+		}{}
+
+		if !iu.ReadJSON(ctx, &reqData) {
+			return
+		}
+
+		page, err := preview.Default.Records(reqData.ID, reqData.Filter)
+		if err != nil {
+			golog.Errorf("Failed to load records of preview %s: %v", reqData.ID, err)
+			ctx.JSON(iu.Resp{
+				Result: false,
+			})
+			return
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+			Data:   page,
+		})
+	})
+
+	app.Post("/preview/commit", func(ctx iris.Context) {
+		reqData := struct {
+			ID string `json:"id"`
+		}{}
+
+		if !iu.ReadJSON(ctx, &reqData) {
+			return
+		}
+
+		err := preview.Default.Commit(context.Background(), outbox.Default, reqData.ID)
+		if err != nil {
+			golog.Errorf("Failed to commit preview %s: %v", reqData.ID, err)
+
+			msg := err.Error()
+			if errors.Is(err, outbox.ErrQueued) {
+				msg = outbox.ErrQueued.Error()
+			}
+
+			ctx.JSON(iu.Resp{
+				Result: false,
+				Data:   msg,
+			})
+			return
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+		})
+	})
+
+	app.Post("/preview/discard", func(ctx iris.Context) {
+		reqData := struct {
+			ID string `json:"id"`
+		}{}
+
+		if !iu.ReadJSON(ctx, &reqData) {
+			return
+		}
+
+		if err := preview.Default.Discard(reqData.ID); err != nil {
+			golog.Errorf("Failed to discard preview %s: %v", reqData.ID, err)
+			ctx.JSON(iu.Resp{
+				Result: false,
+			})
+			return
+		}
+
+		ctx.JSON(iu.Resp{
+			Result: true,
+		})
+	})
+}